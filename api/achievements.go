@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"workout-microservice/internal/achievements"
+	"workout-microservice/internal/data"
+)
+
+type earnedAchievement struct {
+	Code        string    `json:"code"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	WorkoutId   int       `json:"workout_id,omitempty"`
+	EarnedAt    time.Time `json:"earned_at"`
+}
+
+func (app *application) describeAchievements(earned []data.EarnedAchievement) []earnedAchievement {
+	rules := make(map[string]achievements.Rule, len(app.achievementRules))
+	for _, rule := range app.achievementRules {
+		rules[rule.Code] = rule
+	}
+
+	described := make([]earnedAchievement, 0, len(earned))
+	for _, e := range earned {
+		rule, ok := rules[e.Code]
+		if !ok {
+			// the rule has been removed from the rules file since it was earned
+			rule = achievements.Rule{Code: e.Code, Name: e.Code}
+		}
+		described = append(described, earnedAchievement{
+			Code:        e.Code,
+			Name:        rule.Name,
+			Description: rule.Description,
+			WorkoutId:   e.WorkoutId,
+			EarnedAt:    e.EarnedAt,
+		})
+	}
+	return described
+}
+
+func (app *application) getAchievementsHandler(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	if !queryValues.Has(userIdStr) {
+		app.badRequestResponse(w, r, errors.New("user id is missing, must be in the form user_id=? "))
+		return
+	}
+
+	userId, err := strconv.Atoi(queryValues.Get(userIdStr))
+	if err != nil || userId < 1 {
+		app.badRequestResponse(w, r, errors.New("user id must be greater than 0"))
+		return
+	}
+
+	earned, err := app.models.AchievementModel.GetAll(r.Context(), userId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	stats, err := app.models.AchievementModel.Stats(r.Context(), userId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	earnedCodes := make(map[string]bool, len(earned))
+	for _, e := range earned {
+		earnedCodes[e.Code] = true
+	}
+
+	inProgress := []achievements.Progress{}
+	for _, progress := range achievements.Evaluate(app.achievementRules, stats) {
+		if !earnedCodes[progress.Rule.Code] {
+			inProgress = append(inProgress, progress)
+		}
+	}
+
+	env := envelope{
+		"earned":      app.describeAchievements(earned),
+		"in_progress": inProgress,
+	}
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
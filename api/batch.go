@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+	"workout-microservice/internal/data"
+	"workout-microservice/internal/validator"
+)
+
+type batchWorkoutInput struct {
+	UserId     int        `json:"user_id"`
+	ExerciseId int        `json:"exercise_id"`
+	Duration   int        `json:"duration"`
+	Sets       int        `json:"sets"`
+	Reps       []int      `json:"reps"`
+	Weights    []int      `json:"weights"`
+	Rpe        float64    `json:"rpe"`
+	CreatedAt  *time.Time `json:"created_at"`
+}
+
+// maxBatchBytes limits the body of a batch, whether it is a JSON array or
+// NDJSON, and is the largest body any endpoint accepts.
+const maxBatchBytes = 10 * 1_048_576
+
+type batchItemError struct {
+	Index  int         `json:"index"`
+	Errors interface{} `json:"errors"`
+}
+
+// readNDJSON decodes a stream of newline delimited JSON workouts.
+func (app *application) readNDJSON(w http.ResponseWriter, r *http.Request) ([]batchWorkoutInput, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchBytes)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	var inputs []batchWorkoutInput
+	for {
+		var input batchWorkoutInput
+		err := dec.Decode(&input)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", len(inputs)+1, err)
+		}
+		inputs = append(inputs, input)
+		if len(inputs) > data.MaxBatchSize {
+			break
+		}
+	}
+	return inputs, nil
+}
+
+// addWorkoutsBatchHandler inserts a whole session (or more) of workouts logged
+// offline in one transaction. The body is either a JSON array of workouts or,
+// with Content-Type application/x-ndjson, one workout per line.
+func (app *application) addWorkoutsBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var inputs []batchWorkoutInput
+	var err error
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/x-ndjson" || mediaType == "application/ndjson" {
+		inputs, err = app.readNDJSON(w, r)
+	} else {
+		err = app.readJSONLimit(w, r, &inputs, maxBatchBytes)
+	}
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if len(inputs) == 0 {
+		app.badRequestResponse(w, r, errors.New("batch must contain at least one workout"))
+		return
+	}
+	if len(inputs) > data.MaxBatchSize {
+		app.badRequestResponse(w, r, fmt.Errorf("batch must not contain more than %d workouts", data.MaxBatchSize))
+		return
+	}
+
+	workouts := make([]*data.Workout, 0, len(inputs))
+	var itemErrors []batchItemError
+	for i, input := range inputs {
+		workout := &data.Workout{
+			UserId:     input.UserId,
+			ExerciseId: input.ExerciseId,
+			Duration:   input.Duration,
+			Sets:       input.Sets,
+			Reps:       input.Reps,
+			Weights:    input.Weights,
+			Rpe:        input.Rpe,
+		}
+		if input.CreatedAt != nil {
+			workout.CreatedAt = *input.CreatedAt
+		}
+
+		v := validator.New()
+		data.ValidateWorkout(v, workout)
+		v.Check(workout.CreatedAt.Before(time.Now().Add(5*time.Minute)), "created_at", "cannot be in the future")
+		if !v.Valid() {
+			itemErrors = append(itemErrors, batchItemError{Index: i, Errors: v.Errors})
+		}
+		workouts = append(workouts, workout)
+	}
+
+	if len(itemErrors) > 0 {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, envelope{"items": itemErrors})
+		return
+	}
+
+	prChanges, earned, err := app.models.WorkoutModel.InsertBatch(r.Context(), workouts)
+	if err != nil {
+		var batchErr *data.BatchError
+		switch {
+		case errors.As(err, &batchErr):
+			items := make([]batchItemError, 0, len(batchErr.Items))
+			for _, item := range batchErr.Items {
+				items = append(items, batchItemError{Index: item.Index, Errors: item.Err.Error()})
+			}
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, envelope{"items": items})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.metrics.recordWorkouts(len(workouts), prChanges)
+
+	env := envelope{
+		"inserted":     len(workouts),
+		"workouts":     workouts,
+		"pr_changes":   prChanges,
+		"achievements": app.describeAchievements(earned),
+	}
+	err = app.writeJSON(w, r, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
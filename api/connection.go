@@ -3,33 +3,102 @@ package main
 import (
 	"context"
 	"database/sql"
-	_ "github.com/lib/pq"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+
+	"workout-microservice/internal/tracing"
 )
 
-func (app *application) connectDB() *sql.DB {
-	conn, err := sql.Open("postgres", app.config.db.dsn)
-	if err != nil {
-		app.logger.Println("Error while connecting to database: ", err)
-		return nil
+const (
+	driverPostgres = "postgres"
+	driverSQLite   = "sqlite"
+)
+
+// sqlite:///path/to.db selects SQLite, every other dsn is handed to Postgres.
+const sqliteScheme = "sqlite://"
+
+// every SQLite connection enforces foreign keys like Postgres does, waits for
+// the write lock instead of failing with SQLITE_BUSY, and starts transactions
+// with the write lock taken so that two writers cannot deadlock on upgrading.
+const sqliteParams = "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+
+// dbDriver returns the database/sql driver for dsn.
+func dbDriver(dsn string) string {
+	if strings.HasPrefix(dsn, sqliteScheme) {
+		return driverSQLite
+	}
+	return driverPostgres
+}
+
+// connectDB opens the connection pool and waits for the database to answer,
+// retrying with exponential backoff for up to -db-connect-retry.
+func (app *application) connectDB() (*sql.DB, error) {
+	driver, dsn := app.config.db.driver, app.config.db.dsn
+	if driver == driverSQLite {
+		dsn = "file:" + strings.TrimPrefix(dsn, sqliteScheme)
+		if strings.Contains(dsn, "?") {
+			dsn += "&" + sqliteParams
+		} else {
+			dsn += "?" + sqliteParams
+		}
 	}
 
-	conn.SetMaxOpenConns(app.config.db.maxOpenConns)
-	conn.SetMaxIdleConns(app.config.db.maxIdleConns)
 	duration, err := time.ParseDuration(app.config.db.maxIdleTime)
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("invalid -db-max-idle-time: %w", err)
 	}
 
+	var conn *sql.DB
+	if app.tracerProvider != nil {
+		conn, err = tracing.OpenDB(driver, dsn, dbSystem(driver), app.tracerProvider)
+	} else {
+		conn, err = sql.Open(driver, dsn)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetMaxOpenConns(app.config.db.maxOpenConns)
+	conn.SetMaxIdleConns(app.config.db.maxIdleConns)
 	conn.SetConnMaxIdleTime(duration)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	deadline := time.Now().Add(app.config.db.connectRetry)
+	backoff := initialConnectBackoff
+	for attempt := 1; ; attempt++ {
+		err = pingDB(conn)
+		if err == nil {
+			return conn, nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			conn.Close()
+			return nil, fmt.Errorf("connecting to the database: %w", err)
+		}
+		wait := min(backoff, remaining)
 
-	err = conn.PingContext(ctx)
-	if err != nil {
-		app.logger.Println(err)
-		return nil
+		app.logger.PrintInfo("database unreachable, retrying", map[string]string{
+			"driver":  driver,
+			"attempt": strconv.Itoa(attempt),
+			"backoff": wait.String(),
+			"error":   err.Error(),
+		})
+		time.Sleep(wait)
+		backoff = min(2*backoff, maxConnectBackoff)
 	}
-	return conn
+}
+
+const (
+	initialConnectBackoff = 500 * time.Millisecond
+	maxConnectBackoff     = 30 * time.Second
+)
+
+func pingDB(conn *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return conn.PingContext(ctx)
 }
@@ -1,17 +1,32 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"workout-microservice/internal/data"
 )
 
+// statusClientClosedRequest is the status nginx uses for requests the client
+// gave up on before the response was ready.
+const statusClientClosedRequest = 499
+
+// logError logs err with the request it happened in.
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.Println(err)
+	properties := map[string]string{
+		"request_method": r.Method,
+		"request_url":    r.URL.String(),
+		"request_id":     requestID(r),
+	}
+	if id := traceID(r); id != "" {
+		properties["trace_id"] = id
+	}
+	app.logger.PrintError(err, properties)
 }
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
 	env := envelope{"error": message}
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeJSON(w, r, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
@@ -19,11 +34,29 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 }
 
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if data.IsCanceled(err) {
+		app.canceledResponse(w, r, err)
+		return
+	}
+
 	app.logError(r, err)
 	message := "the server encountered a problem and could not serve your request"
 	app.errorResponse(w, r, http.StatusInternalServerError, message)
 }
 
+// canceledResponse answers a request whose database work was cancelled, with
+// 499 when the client went away and 503 when the work ran out of time.
+func (app *application) canceledResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if r.Context().Err() != nil {
+		app.errorResponse(w, r, statusClientClosedRequest, "the request was cancelled by the client")
+		return
+	}
+
+	app.logError(r, err)
+	message := "the server could not complete your request in time, please try again"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
 	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
@@ -45,3 +78,23 @@ func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Reques
 	message := fmt.Sprintf(err.Error())
 	app.errorResponse(w, r, http.StatusBadRequest, message)
 }
+
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
+	message := "rate limit exceeded, slow down and retry later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "unable to update the record due to an edit conflict, please fetch it again and retry"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// preconditionResponse answers a request whose If-Match check failed, with 428
+// when the header is missing and 412 when it names a stale version.
+func (app *application) preconditionResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errPreconditionRequired) {
+		app.errorResponse(w, r, http.StatusPreconditionRequired, "this request requires an If-Match header with the ETag of the resource")
+		return
+	}
+	app.errorResponse(w, r, http.StatusPreconditionFailed, "the resource has been modified since it was fetched, please fetch it again and retry")
+}
@@ -8,6 +8,8 @@ import (
 	"workout-microservice/internal/validator"
 )
 
+var exerciseSortSafelist = []string{"exercise_id", "exercise_name", "-exercise_id", "-exercise_name"}
+
 func (app *application) addExerciseHandler(w http.ResponseWriter, r *http.Request) {
 
 	var input struct {
@@ -32,17 +34,18 @@ func (app *application) addExerciseHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.ExerciseModel.Insert(&exercise)
+	err = app.models.ExerciseModel.Insert(r.Context(), &exercise)
 	if err != nil {
-		app.logger.Println("Error while inserting into database", err)
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	app.logger.Println("Inserted exercise into table successfully")
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/exercises/%d", exercise.ExerciseID))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"exercise": exercise}, headers)
+	headers.Set("ETag", etag(exercise.ExerciseVersion))
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"exercise": exercise}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -57,12 +60,28 @@ func (app *application) deleteExerciseHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err = app.models.ExerciseModel.Delete(ExerciseId)
+	exercise, err := app.models.ExerciseModel.Select(r.Context(), ExerciseId)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.logError(r, err)
-			app.badRequestResponse(w, r, errors.New("please check the exercise or refresh"))
+			app.notFoundResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.checkIfMatch(r, exercise.ExerciseVersion)
+	if err != nil {
+		app.preconditionResponse(w, r, err)
+		return
+	}
+
+	err = app.models.ExerciseModel.Delete(r.Context(), ExerciseId, exercise.ExerciseVersion)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
 			return
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -70,12 +89,11 @@ func (app *application) deleteExerciseHandler(w http.ResponseWriter, r *http.Req
 		}
 	}
 
-	app.logger.Printf("Exercise with id %d deleted successfully!", ExerciseId)
 	message := fmt.Sprintf("Exercise with id %d deleted successfully", ExerciseId)
 	env := envelope{
 		"message": message,
 	}
-	err = app.writeJSON(w, http.StatusOK, env, nil)
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -100,11 +118,11 @@ func (app *application) updateExerciseHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	exercise, err := app.models.ExerciseModel.Select(ExerciseId)
+	exercise, err := app.models.ExerciseModel.Select(r.Context(), ExerciseId)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.badRequestResponse(w, r, err)
+			app.notFoundResponse(w, r, err)
 			return
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -112,6 +130,12 @@ func (app *application) updateExerciseHandler(w http.ResponseWriter, r *http.Req
 		}
 	}
 
+	err = app.checkIfMatch(r, exercise.ExerciseVersion)
+	if err != nil {
+		app.preconditionResponse(w, r, err)
+		return
+	}
+
 	if input.ExerciseName != nil {
 		exercise.ExerciseName = *input.ExerciseName
 	}
@@ -127,11 +151,11 @@ func (app *application) updateExerciseHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err = app.models.ExerciseModel.Update(exercise)
+	err = app.models.ExerciseModel.Update(r.Context(), exercise)
 	if err != nil {
 		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.badRequestResponse(w, r, err)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
 			return
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -139,23 +163,61 @@ func (app *application) updateExerciseHandler(w http.ResponseWriter, r *http.Req
 		}
 	}
 	env := envelope{
-		"message": fmt.Sprintf("Exercise with id %d updated successfully!", exercise.ExerciseID),
+		"message":  fmt.Sprintf("Exercise with id %d updated successfully!", exercise.ExerciseID),
+		"exercise": exercise,
 	}
 
-	err = app.writeJSON(w, http.StatusOK, env, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", etag(exercise.ExerciseVersion))
+
+	err = app.writeJSON(w, r, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getExerciseHandler(w http.ResponseWriter, r *http.Request) {
+	ExerciseId, err := app.readIDParams(r)
+	if err != nil || ExerciseId < 1 {
+		app.notFoundResponse(w, r, fmt.Errorf("invalid exercise id %q", r.URL.Path))
+		return
+	}
+
+	exercise, err := app.models.ExerciseModel.Select(r.Context(), ExerciseId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(exercise.ExerciseVersion))
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"exercise": exercise}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) getExercisesHandler(w http.ResponseWriter, r *http.Request) {
-	exercises, err := app.models.ExerciseModel.SelectAll()
+	v := validator.New()
+	f := app.readFilters(r.URL.Query(), exerciseSortSafelist, v)
+	if !v.Valid() {
+		app.errorResponse(w, r, http.StatusBadRequest, v.Errors)
+		return
+	}
+
+	exercises, metadata, err := app.models.ExerciseModel.SelectAll(r.Context(), f)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"exercises": exercises}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"exercises": exercises, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
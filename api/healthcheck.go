@@ -1,18 +1,145 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
 
+// healthcheckHandler is kept for the clients of the first API version. It runs
+// the readiness checks, so it is unavailable whenever /readyz is.
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks, ready := app.readiness(ctx)
+	status, code := "available", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+
 	env := envelope{
-		"status": "available",
+		"status": status,
 		"system_info": map[string]string{
 			"version":     appVersion,
 			"environment": app.config.env,
 		},
+		"checks": checks,
+	}
+
+	err := app.writeJSON(w, r, code, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// poolSaturationThreshold is the share of the connection pool in use above
+// which the instance stops taking new traffic.
+const poolSaturationThreshold = 0.9
+
+const readinessTimeout = 2 * time.Second
+
+type checkResult struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+const (
+	checkPass = "pass"
+	checkFail = "fail"
+)
+
+// livezHandler reports that the process is up and serving requests. It checks
+// nothing else, so a database outage does not get the process restarted.
+func (app *application) livezHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, r, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readyzHandler reports whether the instance can serve traffic, with the
+// outcome and latency of every check. It answers 503 when a check fails.
+func (app *application) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks, ready := app.readiness(ctx)
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
 	}
 
-	err := app.writeJSON(w, http.StatusOK, env, nil)
+	err := app.writeJSON(w, r, code, envelope{"status": status, "checks": checks}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// readiness runs the checks of the configured storage and reports whether
+// every one of them passed.
+func (app *application) readiness(ctx context.Context) (map[string]checkResult, bool) {
+	checks := map[string]checkResult{}
+	if app.db != nil {
+		// the pool is looked at before the ping takes a connection of its own
+		checks["pool"] = runCheck(ctx, app.checkPool)
+		checks["database"] = runCheck(ctx, app.checkDatabase)
+		checks["schema"] = runCheck(ctx, app.checkSchemaVersion)
+	} else {
+		// the in-memory storage cannot become unavailable, the check only
+		// makes it visible that nothing is persisted
+		checks["storage"] = runCheck(ctx, func(context.Context) (string, error) {
+			return "memory", nil
+		})
+	}
+
+	for _, check := range checks {
+		if check.Status != checkPass {
+			return checks, false
+		}
+	}
+	return checks, true
+}
+
+func runCheck(ctx context.Context, check func(context.Context) (string, error)) checkResult {
+	start := time.Now()
+	detail, err := check(ctx)
+	result := checkResult{Status: checkPass, Latency: time.Since(start).String(), Detail: detail}
+	if err != nil {
+		result.Status = checkFail
+		result.Detail = err.Error()
+	}
+	return result
+}
+
+func (app *application) checkDatabase(ctx context.Context) (string, error) {
+	return "", app.db.PingContext(ctx)
+}
+
+// checkSchemaVersion passes when the schema is at least at the latest
+// migration of this binary, like the check on startup.
+func (app *application) checkSchemaVersion(ctx context.Context) (string, error) {
+	version, dirty, err := app.migrator.Version(ctx)
+	if err != nil {
+		return "", err
+	}
+	if dirty {
+		return "", fmt.Errorf("schema version %d is dirty", version)
+	}
+	if version < app.migrator.Latest() {
+		return "", fmt.Errorf("schema version %d is behind %d", version, app.migrator.Latest())
+	}
+	return fmt.Sprintf("schema version %d, latest migration %d", version, app.migrator.Latest()), nil
+}
+
+func (app *application) checkPool(ctx context.Context) (string, error) {
+	stats := app.db.Stats()
+	detail := fmt.Sprintf("%d of %d connections in use, %d waits", stats.InUse, stats.MaxOpenConnections, stats.WaitCount)
+	if stats.MaxOpenConnections > 0 && float64(stats.InUse) >= poolSaturationThreshold*float64(stats.MaxOpenConnections) {
+		return "", errors.New("connection pool saturated: " + detail)
+	}
+	return detail, nil
+}
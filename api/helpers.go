@@ -4,19 +4,39 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/julienschmidt/httprouter"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"workout-microservice/internal/filters"
+	"workout-microservice/internal/tracing"
+	"workout-microservice/internal/validator"
 )
 
 type envelope map[string]interface{}
 
-func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
+var errEmptyBody = errors.New("body must not be empty")
+
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	// the tracer of the request span is a no-op one when tracing is disabled
+	tracer := trace.SpanFromContext(r.Context()).TracerProvider().Tracer(tracing.InstrumentationName)
+	_, span := tracer.Start(r.Context(), "encode JSON")
 	js, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
 		return err
 	}
+	span.SetAttributes(attribute.Int("http.response.body.size", len(js)))
+	span.End()
 
 	js = append(js, '\n')
 	for key, value := range headers {
@@ -33,8 +53,12 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 }
 
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
-	maxBytes := 1_048_576
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	return app.readJSONLimit(w, r, dst, 1_048_576)
+}
+
+// readJSONLimit is readJSON for bodies that may be larger than the usual 1MB.
+func (app *application) readJSONLimit(w http.ResponseWriter, r *http.Request, dst interface{}, maxBytes int64) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 
@@ -57,7 +81,7 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 			}
 			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
 		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
+			return errEmptyBody
 		case errors.As(err, &invalidUnmarshalError):
 			panic(err)
 		default:
@@ -80,3 +104,121 @@ func (app *application) readIDParams(r *http.Request) (int, error) {
 		return int(i), nil
 	}
 }
+
+func (app *application) readString(qs url.Values, key string, defaultValue string) string {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	return s
+}
+
+func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+	return i
+}
+
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be true or false")
+		return defaultValue
+	}
+	return b
+}
+
+func (app *application) readFloat(qs url.Values, key string, defaultValue float64, v *validator.Validator) float64 {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		v.AddError(key, "must be a number")
+		return defaultValue
+	}
+	return f
+}
+
+// readTime accepts either a full RFC 3339 timestamp or a plain date (2006-01-02),
+// returning the zero time when the key is absent.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return time.Time{}
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		v.AddError(key, "must be a date (2006-01-02) or an RFC 3339 timestamp")
+		return time.Time{}
+	}
+	return t
+}
+
+// readFilters parses the page, page_size, sort, from and to query parameters
+// shared by all list endpoints and validates them against the sort safelist.
+func (app *application) readFilters(qs url.Values, safelist []string, v *validator.Validator) filters.Filters {
+	f := filters.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", filters.DefaultPageSize, v),
+		Sort:         app.readString(qs, "sort", safelist[0]),
+		SortSafelist: safelist,
+		From:         app.readTime(qs, "from", v),
+		To:           app.readTime(qs, "to", v),
+	}
+	filters.Validate(v, f)
+	return f
+}
+
+// etag returns the entity tag of a resource at the given version.
+func etag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+var (
+	errPreconditionRequired = errors.New("missing If-Match header")
+	errPreconditionFailed   = errors.New("If-Match does not match the current version")
+)
+
+// checkIfMatch compares the If-Match header of r against the current version
+// of the resource. The header is required, "*" matches any version.
+func (app *application) checkIfMatch(r *http.Request, version int) error {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return errPreconditionRequired
+	}
+
+	current := etag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return nil
+		}
+	}
+	return errPreconditionFailed
+}
+
+// requestID returns the ID the requestID middleware tagged the request with.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"time"
+	"workout-microservice/internal/insights"
+	"workout-microservice/internal/validator"
+)
+
+// getPlateausHandler runs the plateau detection on demand over the history of
+// one exercise, so the result always reflects the workouts logged so far.
+func (app *application) getPlateausHandler(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	v := validator.New()
+
+	userId := app.readInt(queryValues, userIdStr, 0, v)
+	exerciseId := app.readInt(queryValues, exerciseIdStr, 0, v)
+	opts := insights.PlateauOptions{
+		Sessions:   app.readInt(queryValues, "sessions", insights.DefaultPlateauOptions.Sessions, v),
+		Weeks:      app.readInt(queryValues, "weeks", insights.DefaultPlateauOptions.Weeks, v),
+		Regression: insights.DefaultPlateauOptions.Regression,
+	}
+
+	v.Check(userId > 0, "user id", "should be > 0")
+	v.Check(exerciseId > 0, "exercise id", "should be > 0")
+	v.Check(opts.Sessions >= 2 && opts.Sessions <= 50, "sessions", "should be between 2 and 50")
+	v.Check(opts.Weeks >= 0 && opts.Weeks <= 52, "weeks", "should be between 0 and 52")
+	if !v.Valid() {
+		app.errorResponse(w, r, http.StatusBadRequest, v.Errors)
+		return
+	}
+
+	workouts, err := app.models.WorkoutModel.GetByUserIdAndExerciseId(r.Context(), userId, exerciseId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"user_id":  userId,
+		"plateaus": insights.DetectPlateaus(workouts, opts, time.Now()),
+	}
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
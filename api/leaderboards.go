@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"workout-microservice/internal/data"
+	"workout-microservice/internal/validator"
+)
+
+func (app *application) getLeaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	v := validator.New()
+
+	filter := data.LeaderboardFilter{
+		ExerciseId:  app.readInt(queryValues, exerciseIdStr, 0, v),
+		Metric:      app.readString(queryValues, "metric", data.MetricPr),
+		CallerId:    app.readInt(queryValues, userIdStr, 0, v),
+		Sex:         app.readString(queryValues, "sex", ""),
+		WeightClass: app.readString(queryValues, "weight_class", ""),
+		From:        app.readTime(queryValues, "from", v),
+		To:          app.readTime(queryValues, "to", v),
+		Limit:       app.readInt(queryValues, "limit", 20, v),
+	}
+
+	if !data.ValidateLeaderboardFilter(v, &filter) {
+		app.errorResponse(w, r, http.StatusBadRequest, v.Errors)
+		return
+	}
+
+	leaderboard, err := app.models.LeaderboardModel.Get(r.Context(), filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"leaderboard": leaderboard}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,82 +1,279 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
-	"net/http"
+	"net/netip"
 	"os"
+	"slices"
+	"strings"
+	"sync"
 	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"workout-microservice/internal/achievements"
 	"workout-microservice/internal/data"
+	"workout-microservice/internal/jsonlog"
+	"workout-microservice/internal/migrate"
+	"workout-microservice/internal/ratelimit"
 )
 
 const appVersion = "1.0.0"
 
+const serveUsage = "usage: api [flags]"
+
 type config struct {
-	port int
-	db   struct {
+	port            int
+	shutdownTimeout time.Duration
+	db              struct {
 		dsn          string
+		driver       string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
+		autoMigrate  bool
+		connectRetry time.Duration
+		timeouts     data.Timeouts
 	}
 	env string
+	log struct {
+		level  string
+		format string
+	}
+	storage          string
+	adminToken       string
+	achievementRules string
+	load             struct {
+		acwrHigh float64
+		acwrLow  float64
+	}
+	limiter struct {
+		enabled        bool
+		rps            float64
+		burst          int
+		trustedProxies []netip.Prefix
+	}
+	tracing struct {
+		exporter     string
+		file         string
+		otlpEndpoint string
+		serviceName  string
+	}
+	cors struct {
+		trustedOrigins   []string
+		allowCredentials bool
+	}
+	idempotency struct {
+		ttl             time.Duration
+		cleanupInterval time.Duration
+	}
 }
 
 type application struct {
-	config config
-	logger *log.Logger
-	models data.Models
+	config  config
+	logger  *jsonlog.Logger
+	limiter *ratelimit.Limiter
+	metrics *appMetrics
+	// tracerProvider is nil when tracing is disabled.
+	tracerProvider *sdktrace.TracerProvider
+	models         data.Models
+	// db and migrator are nil with the in-memory storage.
+	db               *sql.DB
+	migrator         *migrate.Migrator
+	achievementRules []achievements.Rule
+	rebuilds         *rebuildJobs
+	// wg tracks the goroutines started by background, shutdown is closed when
+	// the server starts shutting down.
+	wg       sync.WaitGroup
+	shutdown chan struct{}
 }
 
 func main() {
 	var cfg config
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
+	flag.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 30*time.Second, "How long in-flight requests and background tasks get to finish on shutdown")
 	flag.StringVar(&cfg.env, "env", "development", "Environment(development|staging|production")
+	flag.StringVar(&cfg.storage, "storage", "database", "Storage backend (database|memory), the database is SQLite for a sqlite:// dsn and Postgres otherwise")
 	flag.StringVar(
 		&cfg.db.dsn,
 		"dsn",
 		os.Getenv("WORKOUT_DB_DSN"),
-		"Postgres dsn URI")
+		"Postgres dsn URI, or sqlite:///path/to.db for a SQLite database")
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	flag.DurationVar(&cfg.db.timeouts.Read, "db-read-timeout", data.DefaultTimeouts.Read, "Time limit of a database query")
+	flag.DurationVar(&cfg.db.timeouts.Write, "db-write-timeout", data.DefaultTimeouts.Write, "Time limit of a single database write, including its PR recompute")
+	flag.DurationVar(&cfg.db.timeouts.Batch, "db-batch-timeout", data.DefaultTimeouts.Batch, "Time limit of a batch insert or cleanup")
+	flag.DurationVar(&cfg.db.connectRetry, "db-connect-retry", 0, "How long to keep retrying an unreachable database on startup, 0 fails on the first attempt")
+	flag.BoolVar(&cfg.db.autoMigrate, "auto-migrate", false, "Apply pending schema migrations on startup instead of refusing to start")
+	flag.Float64Var(&cfg.load.acwrHigh, "load-acwr-high", 1.5, "ACWR above which a training load spike is flagged")
+	flag.Float64Var(&cfg.load.acwrLow, "load-acwr-low", 0.8, "ACWR below which a day is flagged as undertraining")
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-ttl", 24*time.Hour, "How long responses to requests with an Idempotency-Key are kept for replay")
+	flag.DurationVar(&cfg.idempotency.cleanupInterval, "idempotency-cleanup-interval", time.Hour, "How often expired idempotency keys are deleted (0 disables the cleanup)")
+	flag.StringVar(&cfg.adminToken, "admin-token", os.Getenv("WORKOUT_ADMIN_TOKEN"), "Bearer token for the /v1/admin endpoints (disabled when empty)")
+	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiting per client")
+	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 5, "Requests per second a client may sustain")
+	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 20, "Requests a client may send at once")
+	flag.Func("limiter-trusted-proxies", "Comma separated IPs or CIDRs of proxies whose X-Forwarded-For is trusted", func(val string) error {
+		for _, s := range strings.Split(val, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				addr, aerr := netip.ParseAddr(s)
+				if aerr != nil {
+					return err
+				}
+				prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+			}
+			cfg.limiter.trustedProxies = append(cfg.limiter.trustedProxies, prefix.Masked())
+		}
+		return nil
+	})
+	flag.Func("cors-trusted-origins", "Comma separated origins allowed to call the API from a browser, * for any", func(val string) error {
+		for _, origin := range strings.Split(val, ",") {
+			origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+			if origin != "" {
+				cfg.cors.trustedOrigins = append(cfg.cors.trustedOrigins, origin)
+			}
+		}
+		return nil
+	})
+	flag.BoolVar(&cfg.cors.allowCredentials, "cors-allow-credentials", false, "Allow browsers on the trusted origins to send cookies and Authorization headers")
+	flag.StringVar(&cfg.tracing.exporter, "trace-exporter", "none", "Where spans are exported (none|stdout|file|otlp), stdout interleaves them with the log entries")
+	flag.StringVar(&cfg.tracing.file, "trace-file", "traces.jsonl", "File the file exporter appends a JSON line per span to")
+	flag.StringVar(&cfg.tracing.otlpEndpoint, "trace-otlp-endpoint", otlpEndpointDefault(), "OTLP/HTTP endpoint of the otlp exporter")
+	flag.StringVar(&cfg.tracing.serviceName, "trace-service-name", "workout-api", "service.name of the exported spans")
+	flag.StringVar(&cfg.log.level, "log-level", "info", "Minimum level of logged entries (debug|info|error|fatal|off)")
+	flag.StringVar(&cfg.log.format, "log-format", "json", "Log entry format (json|text)")
+	flag.StringVar(&cfg.achievementRules, "achievement-rules", "", "Path to a JSON file of achievement rules (defaults to the built-in rules)")
 
 	flag.Parse()
+	cfg.db.driver = dbDriver(cfg.db.dsn)
+
+	logLevel, err := jsonlog.ParseLevel(cfg.log.level)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	logFormat, err := jsonlog.ParseFormat(cfg.log.format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	logger := jsonlog.New(os.Stdout, logLevel, logFormat)
+
+	switch flag.Arg(0) {
+	case "", "migrate", "prs":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n%s\n%s\n%s\n", flag.Arg(0), serveUsage, migrateUsage, prsUsage)
+		os.Exit(2)
+	}
+
+	err = run(cfg, logger)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+}
 
-	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+// run serves the API, or runs the migrate or prs command, until it is done.
+// It returns its errors rather than exiting so that its deferred cleanup,
+// flushing the spans and closing the connection pool, always runs.
+func run(cfg config, logger *jsonlog.Logger) error {
+	rules, err := achievements.LoadRulesFile(cfg.achievementRules)
+	if err != nil {
+		return err
+	}
 
 	app := &application{
-		config: cfg,
-		logger: logger,
+		config:           cfg,
+		logger:           logger,
+		achievementRules: rules,
+		rebuilds:         &rebuildJobs{},
+		shutdown:         make(chan struct{}),
+		metrics:          newAppMetrics(),
 	}
 
-	app.routes()
-	conn := app.connectDB()
-	defer func(conn *sql.DB) {
-		err := conn.Close()
+	app.tracerProvider, err = app.newTracerProvider()
+	if err != nil {
+		return err
+	}
+	if app.tracerProvider != nil {
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := app.tracerProvider.Shutdown(ctx); err != nil {
+				logger.PrintError(err, map[string]string{"source": "tracing"})
+			}
+		}()
+	}
+
+	if cfg.cors.allowCredentials && slices.Contains(cfg.cors.trustedOrigins, "*") {
+		return errors.New("-cors-allow-credentials cannot be combined with a * trusted origin")
+	}
+
+	if cfg.limiter.enabled {
+		if cfg.limiter.rps <= 0 || cfg.limiter.burst < 1 {
+			return errors.New("-limiter-rps must be positive and -limiter-burst at least 1")
+		}
+		app.limiter = ratelimit.New(cfg.limiter.rps, cfg.limiter.burst)
+	}
+
+	switch cfg.storage {
+	case "memory":
+		if flag.Arg(0) == "migrate" {
+			return errors.New("migrate requires -storage=database")
+		}
+		app.models = data.NewMemoryModels(rules)
+		logger.PrintInfo("using in-memory storage, all data is lost when the server stops", nil)
+	case "database", "postgres":
+		conn, err := app.connectDB()
 		if err != nil {
-			app.logger.Println("Error while closing database connection")
+			return err
 		}
-	}(conn)
+		defer func(conn *sql.DB) {
+			err := conn.Close()
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}(conn)
 
-	app.models = data.NewModels(conn)
+		if flag.Arg(0) == "migrate" {
+			return app.runMigrate(conn, flag.Args()[1:])
+		}
+
+		err = app.checkSchema(conn)
+		if err != nil {
+			return err
+		}
 
-	logger.Printf("database connection pool established")
+		app.db = conn
+		app.migrator, err = app.newMigrator(conn)
+		if err != nil {
+			return err
+		}
 
-	srv := http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		if cfg.db.driver == driverSQLite {
+			app.models = data.NewSQLiteModels(conn, cfg.db.timeouts, logger, rules)
+		} else {
+			app.models = data.NewModels(conn, cfg.db.timeouts, logger, rules)
+		}
+		app.metrics.registerDB(conn, cfg.db.driver)
+		logger.PrintInfo("database connection pool established", map[string]string{"driver": cfg.db.driver})
+	default:
+		return fmt.Errorf("unknown storage %q, use database or memory", cfg.storage)
 	}
 
-	logger.Printf("Starting server %s on port %s", cfg.env, srv.Addr)
-	err := srv.ListenAndServe()
-	if err != nil {
-		app.logger.Fatal(err)
+	if flag.Arg(0) == "prs" {
+		return app.runPrs(flag.Args()[1:])
 	}
 
+	return app.serve()
 }
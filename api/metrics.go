@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"workout-microservice/internal/data"
+)
+
+const routeContextKey = contextKey("route")
+
+// unmatchedRoute labels requests that did not reach a route handler, so
+// unknown paths do not each get a series of their own.
+const unmatchedRoute = "unmatched"
+
+type appMetrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	inFlight        prometheus.Gauge
+
+	workoutsLogged prometheus.Counter
+	prsSet         prometheus.Counter
+}
+
+// newAppMetrics registers the HTTP, domain and Go runtime metrics on a registry
+// of its own, so that nothing registered globally by a dependency ends up on
+// /metrics.
+func newAppMetrics() *appMetrics {
+	m := &appMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests by method, route and response status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time taken to respond to HTTP requests.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "HTTP requests being served.",
+		}),
+		workoutsLogged: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "workouts_logged_total",
+			Help: "Workouts logged, singly or in batches.",
+		}),
+		prsSet: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "personal_records_set_total",
+			Help: "Personal records raised by logged or updated workouts.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.inFlight,
+		m.workoutsLogged,
+		m.prsSet,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// registerDB exposes the connection pool statistics of db as the go_sql_*
+// metrics, labelled with db_name.
+func (m *appMetrics) registerDB(db *sql.DB, name string) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// recordWorkouts counts logged workouts and the PRs they raised.
+func (m *appMetrics) recordWorkouts(logged int, changes []data.PrChange) {
+	m.workoutsLogged.Add(float64(logged))
+
+	raised := 0
+	for _, change := range changes {
+		if change.Current > change.Previous {
+			raised++
+		}
+	}
+	m.prsSet.Add(float64(raised))
+}
+
+// instrument counts every request and its latency by route and status.
+func (app *application) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		app.metrics.inFlight.Inc()
+		defer app.metrics.inFlight.Dec()
+
+		route := unmatchedRoute
+		ctx := context.WithValue(r.Context(), routeContextKey, &route)
+		sw := &statusResponseWriter{ResponseWriter: w}
+
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		app.metrics.requests.WithLabelValues(r.Method, route, strconv.Itoa(sw.status)).Inc()
+		app.metrics.requestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// route tags the requests of handler with the route pattern for instrument.
+func (app *application) route(pattern string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(routeContextKey).(*string); ok {
+			*route = pattern
+		}
+		handler(w, r)
+	}
+}
+
+// metricsHandler serves the registry in the Prometheus exposition format
+// negotiated with the scraper.
+func (app *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	promhttp.HandlerFor(app.metrics.registry, promhttp.HandlerOpts{
+		ErrorLog: promErrorLog{app: app, r: r},
+	}).ServeHTTP(w, r)
+}
+
+// promErrorLog hands the errors promhttp runs into while writing a scrape to
+// the application log.
+type promErrorLog struct {
+	app *application
+	r   *http.Request
+}
+
+func (l promErrorLog) Println(v ...interface{}) {
+	l.app.logError(l.r, errors.New(fmt.Sprint(v...)))
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"workout-microservice/internal/data"
+	"workout-microservice/internal/tracing"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// maxRequestIDLength bounds the X-Request-ID values taken over from clients.
+const maxRequestIDLength = 128
+
+type contextKey string
+
+const requestIDContextKey = contextKey("requestID")
+
+// middleware wraps a handler with behaviour shared by every route.
+type middleware func(http.Handler) http.Handler
+
+// chain wraps h in the middlewares, the first one being the outermost.
+func chain(h http.Handler, middlewares ...middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// requestID tags the request with the X-Request-ID sent by the client or a
+// proxy, generating one when it is missing or unusable, and echoes it in the
+// response.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// statusResponseWriter records the status and size of the response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (sw *statusResponseWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusResponseWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += n
+	return n, err
+}
+
+func (sw *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// logRequests writes an access log entry for every request once its response
+// has been sent.
+func (app *application) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusResponseWriter{ResponseWriter: w}
+
+		next.ServeHTTP(sw, r)
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		properties := map[string]string{
+			"request_id":     requestID(r),
+			"request_method": r.Method,
+			"request_url":    r.URL.String(),
+			"remote_addr":    r.RemoteAddr,
+			"status":         strconv.Itoa(sw.status),
+			"bytes":          strconv.Itoa(sw.bytes),
+			"duration":       time.Since(start).String(),
+		}
+		if id := traceID(r); id != "" {
+			properties["trace_id"] = id
+		}
+		app.logger.PrintInfo("request", properties)
+	})
+}
+
+// recoverPanic turns a panic in a handler into a 500 response and closes the
+// connection, instead of dropping it without a response.
+func (app *application) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				w.Header().Set("Connection", "close")
+				app.serverErrorResponse(w, r, fmt.Errorf("panic: %v", err))
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// capturingResponseWriter passes the response through to the client while
+// keeping a copy of the status and body.
+type capturingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (cw *capturingResponseWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *capturingResponseWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.body.Write(b)
+	return cw.ResponseWriter.Write(b)
+}
+
+// hopByHopHeaders only apply to a single connection and are never replayed.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// idempotency honours the Idempotency-Key header on POST and PATCH requests.
+// The first request with a key runs normally and its response is stored, a
+// retry with the same key and body gets the stored response replayed, and
+// reusing the key for a different request is rejected with 422. Responses
+// with a 5xx status are not stored so the client can retry them. Keys are
+// scoped to the client and the endpoint, so two clients picking the same key
+// never see each other's responses.
+func (app *application) idempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch) || operationalPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			app.badRequestResponse(w, r, fmt.Errorf("Idempotency-Key must not be longer than %d characters", maxIdempotencyKeyLength))
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxBatchBytes)
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.RequestURI())
+		hash.Write(body)
+		fingerprint := hash.Sum(nil)
+
+		key = fmt.Sprintf("%s %s %s %s", app.clientKey(r), r.Method, r.URL.Path, key)
+
+		stored, err := app.models.IdempotencyModel.Reserve(r.Context(), key, fingerprint, app.config.idempotency.ttl)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrIdempotencyKeyMismatch):
+				app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+			case errors.Is(err, data.ErrIdempotencyKeyInProgress):
+				app.errorResponse(w, r, http.StatusConflict, err.Error())
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if stored != nil {
+			for name, values := range stored.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			_, err = w.Write(stored.Body)
+			if err != nil {
+				app.logError(r, err)
+			}
+			return
+		}
+
+		cw := &capturingResponseWriter{ResponseWriter: w}
+		completed := false
+		// the key is settled even when the client has gone away in the meantime
+		settleCtx := context.WithoutCancel(r.Context())
+		defer func() {
+			if !completed {
+				// the handler panicked or failed, let the client retry with the same key
+				if err := app.models.IdempotencyModel.Release(settleCtx, key); err != nil {
+					app.logError(r, err)
+				}
+			}
+		}()
+
+		// headers set by the outer middleware belong to this request only
+		before := w.Header().Clone()
+		next.ServeHTTP(cw, r)
+
+		// a request the client gave up on did not finish either
+		if cw.status == 0 || cw.status >= http.StatusInternalServerError || cw.status == statusClientClosedRequest {
+			return
+		}
+
+		header := make(http.Header)
+		for name, values := range cw.Header() {
+			if !slices.Equal(before[name], values) && !slices.Contains(hopByHopHeaders, name) {
+				header[name] = values
+			}
+		}
+
+		err = app.models.IdempotencyModel.Complete(settleCtx, key, data.StoredResponse{
+			Status: cw.status,
+			Header: header,
+			Body:   cw.body.Bytes(),
+		})
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+		completed = true
+	})
+}
+
+const (
+	limiterCleanupInterval = time.Minute
+	// limiterIdleTime is how long a client's bucket is kept after its last
+	// request. Buckets idle long enough to have refilled are forgotten safely.
+	limiterIdleTime = 3 * time.Minute
+)
+
+// operationalPaths are polled by Prometheus and the orchestrator rather than by
+// clients, so they are neither rate limited nor go through idempotency.
+var operationalPaths = map[string]bool{
+	"/metrics": true,
+	"/livez":   true,
+	"/readyz":  true,
+}
+
+// rateLimit applies the token bucket of the client to every request, adding
+// the RateLimit-* headers to the response and rejecting requests over the
+// limit with 429.
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.limiter == nil || operationalPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		res := app.limiter.Allow(app.clientKey(r))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+		if !res.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			app.rateLimitExceededResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientKey identifies whose bucket a request is counted against. The API has
+// no user accounts of its own, so requests carrying the admin token share the
+// admin bucket and everything else is limited per client IP.
+func (app *application) clientKey(r *http.Request) string {
+	if app.isAdmin(r) {
+		return "admin"
+	}
+	return "ip:" + app.clientIP(r)
+}
+
+// clientIP is the address the request came from. When it came through one of
+// the trusted proxies, the client is the last address in X-Forwarded-For that
+// is not a trusted proxy itself.
+func (app *application) clientIP(r *http.Request) string {
+	remote, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	ip := remote.Addr().Unmap()
+	if !app.trustedProxy(ip) {
+		return ip.String()
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			// everything to the left of a malformed entry is made up
+			break
+		}
+		ip = hop.Unmap()
+		if !app.trustedProxy(ip) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+func (app *application) trustedProxy(ip netip.Addr) bool {
+	for _, prefix := range app.config.limiter.trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+const (
+	corsAllowedMethods = "GET, POST, PUT, PATCH, DELETE"
+	corsAllowedHeaders = "Authorization, Content-Type, Idempotency-Key, If-Match, X-Request-ID"
+	corsExposedHeaders = "ETag, Location, Idempotent-Replayed, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After, X-Request-ID"
+	corsMaxAge         = "600"
+)
+
+// enableCORS lets browsers on the trusted origins call the API. Preflight
+// requests from those origins are answered here without reaching the router.
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" || !app.trustedOrigin(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if app.config.cors.allowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) trustedOrigin(origin string) bool {
+	for _, trusted := range app.config.cors.trustedOrigins {
+		if trusted == "*" || strings.EqualFold(trusted, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// trace records a server span for every request, continuing the trace of the
+// caller when the request carries a W3C traceparent header.
+func (app *application) trace(next http.Handler) http.Handler {
+	if app.tracerProvider == nil {
+		return next
+	}
+	tracer := app.tracerProvider.Tracer(tracing.InstrumentationName)
+	propagator := otel.GetTextMapPropagator()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", app.clientIP(r)),
+				attribute.String("request.id", requestID(r))))
+		defer span.End()
+
+		sw := &statusResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		if route, ok := ctx.Value(routeContextKey).(*string); ok {
+			span.SetName(r.Method + " " + *route)
+			span.SetAttributes(attribute.String("http.route", *route))
+		}
+		span.SetAttributes(
+			attribute.Int("http.response.status_code", sw.status),
+			attribute.Int("http.response.body.size", sw.bytes))
+		if sw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sw.status))
+		}
+	})
+}
+
+// traceID is the ID of the trace the request is part of, empty when tracing
+// is disabled.
+func traceID(r *http.Request) string {
+	sc := trace.SpanContextFromContext(r.Context())
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
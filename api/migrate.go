@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"workout-microservice/internal/migrate"
+	"workout-microservice/migrations"
+)
+
+const migrateUsage = "usage: api [flags] migrate up|down [n]|status|goto <version>"
+
+// runMigrate implements the migrate subcommand.
+func (app *application) runMigrate(conn *sql.DB, args []string) error {
+	m, err := app.newMigrator(conn)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	ctx := context.Background()
+	var done []migrate.Migration
+
+	switch args[0] {
+	case "up":
+		done, err = m.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		done, err = m.Down(ctx, steps)
+	case "goto":
+		if len(args) < 2 {
+			return errors.New(migrateUsage)
+		}
+		version, perr := strconv.ParseInt(args[1], 10, 64)
+		if perr != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		done, err = m.Goto(ctx, version)
+	case "status":
+		return app.printMigrationStatus(ctx, m)
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	for _, migration := range done {
+		app.logger.PrintInfo("migrated", map[string]string{"migration": migration.String(), "direction": args[0]})
+	}
+	if err != nil {
+		return err
+	}
+	if len(done) == 0 {
+		app.logger.PrintInfo("no change", nil)
+	}
+	return nil
+}
+
+func (app *application) printMigrationStatus(ctx context.Context, m *migrate.Migrator) error {
+	statuses, version, dirty, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		state := "pending"
+		if status.Applied {
+			state = "applied"
+		}
+		fmt.Printf("%-8s %s\n", state, status.Migration)
+	}
+	fmt.Printf("schema version %d of %d", version, m.Latest())
+	if dirty {
+		fmt.Print(" (dirty)")
+	}
+	fmt.Println()
+	return nil
+}
+
+// newMigrator returns the migrator for the schema of the configured database.
+func (app *application) newMigrator(conn *sql.DB) (*migrate.Migrator, error) {
+	if app.config.db.driver == driverSQLite {
+		return migrate.NewSQLite(conn, migrations.SQLiteFS)
+	}
+	return migrate.New(conn, migrations.FS)
+}
+
+// checkSchema makes sure the database is at the latest migration before the
+// server starts, applying pending migrations when -auto-migrate is set.
+func (app *application) checkSchema(conn *sql.DB) error {
+	m, err := app.newMigrator(conn)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	version, dirty, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return migrate.ErrDirty
+	}
+
+	switch {
+	case version == m.Latest():
+		return nil
+	case version > m.Latest():
+		// an older binary during a rolling deploy, migrations are additive
+		app.logger.PrintInfo("schema is newer than the latest migration of this binary", map[string]string{
+			"version": strconv.FormatInt(version, 10),
+			"latest":  strconv.FormatInt(m.Latest(), 10),
+		})
+		return nil
+	case !app.config.db.autoMigrate:
+		return fmt.Errorf("schema version %d is behind %d, run `api migrate up` or start with -auto-migrate", version, m.Latest())
+	}
+
+	done, err := m.Up(ctx)
+	for _, migration := range done {
+		app.logger.PrintInfo("migrated", map[string]string{"migration": migration.String(), "direction": "up"})
+	}
+	return err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"workout-microservice/internal/data"
+	"workout-microservice/internal/plates"
+	"workout-microservice/internal/validator"
+)
+
+func (app *application) getProfileHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := app.readIDParams(r)
+	if err != nil || userId < 1 {
+		app.badRequestResponse(w, r, errors.New("user id must be greater than 0"))
+		return
+	}
+
+	profile, err := app.models.ProfileModel.Get(r.Context(), userId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"profile": profile}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) putProfileHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := app.readIDParams(r)
+	if err != nil || userId < 1 {
+		app.badRequestResponse(w, r, errors.New("user id must be greater than 0"))
+		return
+	}
+
+	var input struct {
+		Bodyweight       float64   `json:"bodyweight"`
+		Sex              string    `json:"sex"`
+		LeaderboardOptIn bool      `json:"leaderboard_opt_in"`
+		Unit             string    `json:"unit"`
+		BarWeight        float64   `json:"bar_weight"`
+		Plates           []float64 `json:"plates"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	profile := data.Profile{
+		UserId:           userId,
+		Bodyweight:       input.Bodyweight,
+		Sex:              input.Sex,
+		LeaderboardOptIn: input.LeaderboardOptIn,
+		Unit:             input.Unit,
+		BarWeight:        input.BarWeight,
+		Plates:           input.Plates,
+	}
+	if profile.Unit == "" {
+		profile.Unit = plates.UnitKg
+	}
+
+	v := validator.New()
+	if !data.ValidateProfile(v, &profile) {
+		app.errorResponse(w, r, http.StatusBadRequest, v.Errors)
+		return
+	}
+
+	err = app.models.ProfileModel.Upsert(r.Context(), &profile)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"profile": profile}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -15,6 +15,9 @@ var (
 	prStr         = "personal_record"
 )
 
+var prSortSafelist = []string{"exercise_id", "exercise_name", "pr", "achieved_at",
+	"-exercise_id", "-exercise_name", "-pr", "-achieved_at"}
+
 func (app *application) getPersonalRecordsHandlerByUserIdAndExerciseId(w http.ResponseWriter, r *http.Request) {
 
 	// I plan to restructure this get call on the basis of user id and exercise id.
@@ -27,11 +30,13 @@ func (app *application) getPersonalRecordsHandlerByUserIdAndExerciseId(w http.Re
 	// fetch the user id first
 	if !queryValues.Has(userIdStr) {
 		app.badRequestResponse(w, r, errors.New("user id is missing, must be in the form user_id=? "))
+		return
 	}
 
 	userId, err := strconv.ParseInt(queryValues.Get(userIdStr), 10, 64)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
+		return
 	}
 
 	var exerciseId int64
@@ -43,7 +48,7 @@ func (app *application) getPersonalRecordsHandlerByUserIdAndExerciseId(w http.Re
 
 	var env envelope
 	if exerciseId > 0 {
-		fetchedPr, err2 := app.models.PrModel.Get(int(userId), int(exerciseId))
+		fetchedPr, err2 := app.models.PrModel.Get(r.Context(), int(userId), int(exerciseId))
 		if err2 != nil {
 			app.serverErrorResponse(w, r, err2)
 			return
@@ -52,17 +57,25 @@ func (app *application) getPersonalRecordsHandlerByUserIdAndExerciseId(w http.Re
 			"pr": []data.ConsolidatedPr{*fetchedPr},
 		}
 	} else {
-		prList, err2 := app.models.PrModel.GetAll(int(userId))
+		v := validator.New()
+		f := app.readFilters(queryValues, prSortSafelist, v)
+		if !v.Valid() {
+			app.errorResponse(w, r, http.StatusBadRequest, v.Errors)
+			return
+		}
+
+		prList, metadata, err2 := app.models.PrModel.GetAll(r.Context(), int(userId), f)
 		if err2 != nil {
 			app.serverErrorResponse(w, r, err2)
 			return
 		}
 		env = envelope{
-			"pr": prList,
+			"pr":       prList,
+			"metadata": metadata,
 		}
 	}
 
-	err = app.writeJSON(w, http.StatusOK, env, nil)
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -72,26 +85,20 @@ func (app *application) getPersonalRecordsHandlerByUserIdAndExerciseId(w http.Re
 func (app *application) deletePersonalRecordsHandler(w http.ResponseWriter, r *http.Request) {
 	err, pr, done := app.getPrQueryParams(w, r, false)
 	if !done || err != nil {
-
-		fmt.Printf("error occurred while deleting personal record with user id: %d and exercise id: %d\n",
-			pr.UserId, pr.ExerciseId)
 		return
 	}
 
-	err = app.models.PrModel.Delete(pr)
+	err = app.models.PrModel.Delete(r.Context(), pr)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	app.logger.Printf("personal record with user id: %d and exercise id: %d deleted successfully \n",
-		pr.UserId, pr.ExerciseId)
-
 	env := envelope{
 		"message": fmt.Sprintf("personal record with user id: %d and exercise id: %d deleted successfully \n",
 			pr.UserId, pr.ExerciseId),
 	}
-	err = app.writeJSON(w, http.StatusOK, env, nil)
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -101,15 +108,12 @@ func (app *application) deletePersonalRecordsHandler(w http.ResponseWriter, r *h
 func (app *application) updatePersonalRecordsHandler(w http.ResponseWriter, r *http.Request) {
 	err, pr, done := app.getPrQueryParams(w, r, true)
 	if !done || err != nil {
-
-		fmt.Printf("error occurred while updating personal record with user id: %d and exercise id: %d\n",
-			pr.UserId, pr.ExerciseId)
 		app.badRequestResponse(w, r, errors.New(fmt.Sprintf("error occurred while parsing personal record with user id: %d and exercise id: %d\n",
 			pr.UserId, pr.ExerciseId)))
 		return
 	}
 
-	err = app.models.PrModel.Update(pr)
+	err = app.models.PrModel.Update(r.Context(), pr)
 	if err != nil {
 		if errors.Is(err, data.ErrRecordNotFound) {
 			app.badRequestResponse(w, r, err)
@@ -120,14 +124,11 @@ func (app *application) updatePersonalRecordsHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	app.logger.Printf("personal record with user id: %d and exercise id: %d updated successfully \n",
-		pr.UserId, pr.ExerciseId)
-
 	env := envelope{
 		"message": fmt.Sprintf("personal record with user id: %d and exercise id: %d updated successfully \n",
 			pr.UserId, pr.ExerciseId),
 	}
-	err = app.writeJSON(w, http.StatusOK, env, nil)
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -166,18 +167,16 @@ func (app *application) addPersonalRecordsHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	err = app.models.PrModel.Insert(pr)
+	err = app.models.PrModel.Insert(r.Context(), pr)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	app.logger.Printf("personal record with user id: %d and exercise id: %d inserted successfully \n",
-		pr.UserId, pr.ExerciseId)
 	env := envelope{
 		"message": fmt.Sprintf("personal record with user id: %d and exercise id: %d inserted successfully \n",
 			pr.UserId, pr.ExerciseId),
 	}
-	err = app.writeJSON(w, http.StatusOK, env, nil)
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"workout-microservice/internal/data"
+	"workout-microservice/internal/validator"
+)
+
+const prsUsage = "usage: api [flags] prs rebuild [-user-id n] [-exercise-id n] [-dry-run] [-batch-size n] [-report file]"
+
+// runPrs implements the prs subcommand.
+func (app *application) runPrs(args []string) error {
+	if len(args) == 0 || args[0] != "rebuild" {
+		return errors.New(prsUsage)
+	}
+
+	var opts data.RebuildOptions
+	var reportPath string
+
+	fs := flag.NewFlagSet("prs rebuild", flag.ContinueOnError)
+	fs.IntVar(&opts.UserId, "user-id", 0, "Only rebuild the PRs of this user")
+	fs.IntVar(&opts.ExerciseId, "exercise-id", 0, "Only rebuild the PRs of this exercise")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "Report what would change without writing anything")
+	fs.IntVar(&opts.BatchSize, "batch-size", data.DefaultRebuildBatchSize, "Number of PRs recomputed per transaction")
+	fs.StringVar(&reportPath, "report", "-", "File to write the JSON report of corrected PRs to, - for stdout")
+	err := fs.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+	if !validateRebuildOptions(v, opts) {
+		return errors.New(formatValidationErrors(v.Errors))
+	}
+
+	report, err := app.rebuildPrs(context.Background(), opts, nil)
+	if report == nil {
+		return err
+	}
+
+	// a failed rebuild still writes the report of the batches it committed
+	return errors.Join(err, writeRebuildReport(reportPath, report))
+}
+
+func writeRebuildReport(path string, report *data.RebuildReport) error {
+	var out io.Writer = os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// rebuildPrs runs a PR rebuild and logs its progress. progress, if not nil,
+// is called after every batch as well. When a batch fails the report of the
+// batches committed before it is returned together with the error.
+func (app *application) rebuildPrs(ctx context.Context, opts data.RebuildOptions, progress func(data.RebuildProgress)) (*data.RebuildReport, error) {
+	app.logger.PrintInfo("rebuilding PRs", map[string]string{
+		"user_id":     strconv.Itoa(opts.UserId),
+		"exercise_id": strconv.Itoa(opts.ExerciseId),
+		"dry_run":     strconv.FormatBool(opts.DryRun),
+	})
+
+	report, err := app.models.PrModel.Rebuild(ctx, opts, func(p data.RebuildProgress) {
+		app.logger.PrintInfo("rebuilding PRs", map[string]string{
+			"checked":   strconv.Itoa(p.Done),
+			"total":     strconv.Itoa(p.Total),
+			"corrected": strconv.Itoa(p.Corrected),
+		})
+		if progress != nil {
+			progress(p)
+		}
+	})
+	if err != nil {
+		properties := map[string]string{"source": "PR rebuild"}
+		if report != nil {
+			properties["checked"] = strconv.Itoa(report.Checked)
+			properties["corrected"] = strconv.Itoa(len(report.Corrected))
+		}
+		app.logger.PrintError(err, properties)
+		return report, err
+	}
+
+	app.logger.PrintInfo("rebuilt PRs", map[string]string{
+		"duration":  report.Duration,
+		"checked":   strconv.Itoa(report.Checked),
+		"corrected": strconv.Itoa(len(report.Corrected)),
+	})
+	return report, nil
+}
+
+func validateRebuildOptions(v *validator.Validator, opts data.RebuildOptions) bool {
+	v.Check(opts.UserId >= 0, "user_id", "must not be negative")
+	v.Check(opts.ExerciseId >= 0, "exercise_id", "must not be negative")
+	v.Check(opts.BatchSize >= 0, "batch_size", "must not be negative")
+	v.Check(opts.BatchSize <= 10_000, "batch_size", "must be a maximum of 10000")
+	return v.Valid()
+}
+
+func formatValidationErrors(errs map[string]string) string {
+	messages := make([]string, 0, len(errs))
+	for key, message := range errs {
+		messages = append(messages, key+" "+message)
+	}
+	return strings.Join(messages, ", ")
+}
+
+// requireAdmin only lets requests with the configured admin token through.
+// Admin endpoints are disabled entirely when no token is configured.
+func (app *application) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.config.adminToken == "" {
+			app.notFoundResponse(w, r, errors.New("admin endpoints are disabled, set -admin-token to enable them"))
+			return
+		}
+
+		if !app.isAdmin(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.errorResponse(w, r, http.StatusUnauthorized, "invalid or missing admin token")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// isAdmin reports whether the request carries the configured admin token.
+func (app *application) isAdmin(r *http.Request) bool {
+	if app.config.adminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(app.config.adminToken)) == 1
+}
+
+// rebuildQueryParams are the options rebuildPrsHandler takes from the query
+// string, as an alternative to the JSON body.
+var rebuildQueryParams = []string{"user_id", "exercise_id", "dry_run", "batch_size"}
+
+// rebuildPrsHandler starts recomputing PRs from the workout history in the
+// background. It responds with 202 and the job, whose Location serves the
+// progress and, once done, the report of corrected rows. Options come from the
+// JSON body, from the query string or both, the query string taking
+// precedence. Only one rebuild runs at a time.
+func (app *application) rebuildPrsHandler(w http.ResponseWriter, r *http.Request) {
+	var opts data.RebuildOptions
+
+	err := app.readJSON(w, r, &opts)
+	if err != nil && !errors.Is(err, errEmptyBody) {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	qs := r.URL.Query()
+	for key := range qs {
+		if !slices.Contains(rebuildQueryParams, key) {
+			app.badRequestResponse(w, r, fmt.Errorf("unknown query parameter %q", key))
+			return
+		}
+	}
+
+	v := validator.New()
+	opts.UserId = app.readInt(qs, "user_id", opts.UserId, v)
+	opts.ExerciseId = app.readInt(qs, "exercise_id", opts.ExerciseId, v)
+	opts.DryRun = app.readBool(qs, "dry_run", opts.DryRun, v)
+	opts.BatchSize = app.readInt(qs, "batch_size", opts.BatchSize, v)
+	if !v.Valid() || !validateRebuildOptions(v, opts) {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	job, running := app.rebuilds.start(opts)
+	if running != nil {
+		headers := make(http.Header)
+		headers.Set("Location", running.location())
+		err = app.writeJSON(w, r, http.StatusConflict, envelope{"error": "a PR rebuild is already running", "job": running.snapshot()}, headers)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.background(func() {
+		// a rebuild commits batch by batch, so stopping it on shutdown
+		// leaves every PR it did not get to as it was
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-app.shutdown:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		report, err := app.rebuildPrs(ctx, opts, job.progressed)
+		job.finish(report, err)
+	})
+
+	headers := make(http.Header)
+	headers.Set("Location", job.location())
+
+	err = app.writeJSON(w, r, http.StatusAccepted, envelope{"job": job.snapshot()}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showRebuildHandler responds with a rebuild job started by rebuildPrsHandler.
+func (app *application) showRebuildHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r, errors.New("invalid rebuild job id"))
+		return
+	}
+
+	job := app.rebuilds.get(id)
+	if job == nil {
+		app.notFoundResponse(w, r, fmt.Errorf("no rebuild job %d, jobs are kept in memory until restart", id))
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"job": job.snapshot()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+const (
+	rebuildRunning = "running"
+	rebuildDone    = "done"
+	rebuildFailed  = "failed"
+
+	// maxRebuildJobs is how many finished jobs are kept for their reports.
+	maxRebuildJobs = 20
+)
+
+// rebuildJob is a PR rebuild started over HTTP. Its fields are guarded by the
+// mutex of the rebuildJobs it belongs to.
+type rebuildJob struct {
+	ID         int                  `json:"id"`
+	Status     string               `json:"status"`
+	Options    data.RebuildOptions  `json:"options"`
+	Progress   data.RebuildProgress `json:"progress"`
+	Report     *data.RebuildReport  `json:"report,omitempty"`
+	Error      string               `json:"error,omitempty"`
+	StartedAt  time.Time            `json:"started_at"`
+	FinishedAt *time.Time           `json:"finished_at,omitempty"`
+
+	jobs *rebuildJobs
+}
+
+// rebuildJobs keeps the rebuild jobs of this process in memory.
+type rebuildJobs struct {
+	mu     sync.Mutex
+	nextId int
+	jobs   []*rebuildJob
+}
+
+// start registers a new running job, or returns the job that is still running.
+func (j *rebuildJobs) start(opts data.RebuildOptions) (*rebuildJob, *rebuildJob) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, job := range j.jobs {
+		if job.Status == rebuildRunning {
+			return nil, job
+		}
+	}
+
+	j.nextId++
+	job := &rebuildJob{
+		ID:        j.nextId,
+		Status:    rebuildRunning,
+		Options:   opts,
+		StartedAt: time.Now().UTC(),
+		jobs:      j,
+	}
+	j.jobs = append(j.jobs, job)
+	if len(j.jobs) > maxRebuildJobs {
+		j.jobs = j.jobs[len(j.jobs)-maxRebuildJobs:]
+	}
+	return job, nil
+}
+
+func (j *rebuildJobs) get(id int) *rebuildJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, job := range j.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+func (job *rebuildJob) location() string {
+	return fmt.Sprintf("/v1/admin/prs/rebuild/%d", job.ID)
+}
+
+func (job *rebuildJob) progressed(p data.RebuildProgress) {
+	job.jobs.mu.Lock()
+	defer job.jobs.mu.Unlock()
+	job.Progress = p
+}
+
+func (job *rebuildJob) finish(report *data.RebuildReport, err error) {
+	job.jobs.mu.Lock()
+	defer job.jobs.mu.Unlock()
+
+	now := time.Now().UTC()
+	job.FinishedAt = &now
+	job.Report = report
+	if err != nil {
+		job.Status = rebuildFailed
+		job.Error = err.Error()
+		return
+	}
+	job.Status = rebuildDone
+}
+
+// snapshot copies the job so that it can be encoded without holding the lock.
+func (job *rebuildJob) snapshot() rebuildJob {
+	job.jobs.mu.Lock()
+	defer job.jobs.mu.Unlock()
+	return *job
+}
@@ -1,27 +1,61 @@
 package main
 
 import (
+	"fmt"
 	"github.com/julienschmidt/httprouter"
 	"net/http"
 )
 
-func (app *application) routes() *httprouter.Router {
+func (app *application) routes() http.Handler {
 	router := httprouter.New()
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-
-	router.HandlerFunc(http.MethodPost, "/v1/exercises", app.addExerciseHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/exercises/:id", app.deleteExerciseHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/exercises/:id", app.updateExerciseHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/exercises", app.getExercisesHandler)
-
-	router.HandlerFunc(http.MethodGet, "/v1/prs", app.getPersonalRecordsHandlerByUserIdAndExerciseId)
-	router.HandlerFunc(http.MethodPost, "/v1/prs", app.addPersonalRecordsHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/prs", app.deletePersonalRecordsHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/prs", app.updatePersonalRecordsHandler)
-
-	router.HandlerFunc(http.MethodPost, "/v1/workouts", app.addWorkoutHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/workouts/:workout_id", app.deleteWorkoutHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/workouts/", app.UpdateWorkoutHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/workouts", app.getWorkoutsHandler)
-	return router
+	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.notFoundResponse(w, r, fmt.Errorf("no route for %s", r.URL.Path))
+	})
+	router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.methodNotAllowed(w, r, fmt.Errorf("%s is not allowed for %s", r.Method, r.URL.Path))
+	})
+
+	handle := func(method, path string, handler http.HandlerFunc) {
+		router.HandlerFunc(method, path, app.route(path, handler))
+	}
+
+	handle(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	handle(http.MethodGet, "/metrics", app.metricsHandler)
+	handle(http.MethodGet, "/livez", app.livezHandler)
+	handle(http.MethodGet, "/readyz", app.readyzHandler)
+
+	handle(http.MethodPost, "/v1/exercises", app.addExerciseHandler)
+	handle(http.MethodDelete, "/v1/exercises/:id", app.deleteExerciseHandler)
+	handle(http.MethodPatch, "/v1/exercises/:id", app.updateExerciseHandler)
+	handle(http.MethodGet, "/v1/exercises", app.getExercisesHandler)
+	handle(http.MethodGet, "/v1/exercises/:id", app.getExerciseHandler)
+
+	handle(http.MethodGet, "/v1/prs", app.getPersonalRecordsHandlerByUserIdAndExerciseId)
+	handle(http.MethodPost, "/v1/prs", app.addPersonalRecordsHandler)
+	handle(http.MethodDelete, "/v1/prs", app.deletePersonalRecordsHandler)
+	handle(http.MethodPatch, "/v1/prs", app.updatePersonalRecordsHandler)
+	handle(http.MethodPost, "/v1/admin/prs/rebuild", app.requireAdmin(app.rebuildPrsHandler))
+	handle(http.MethodGet, "/v1/admin/prs/rebuild/:id", app.requireAdmin(app.showRebuildHandler))
+
+	handle(http.MethodPost, "/v1/workouts", app.addWorkoutHandler)
+	handle(http.MethodPost, "/v1/workouts/batch", app.addWorkoutsBatchHandler)
+	handle(http.MethodDelete, "/v1/workouts/:workout_id", app.deleteWorkoutHandler)
+	handle(http.MethodPatch, "/v1/workouts/", app.UpdateWorkoutHandler)
+	handle(http.MethodGet, "/v1/workouts", app.getWorkoutsHandler)
+
+	handle(http.MethodGet, "/v1/users/:id/profile", app.getProfileHandler)
+	handle(http.MethodPut, "/v1/users/:id/profile", app.putProfileHandler)
+
+	handle(http.MethodGet, "/v1/leaderboards", app.getLeaderboardHandler)
+
+	handle(http.MethodGet, "/v1/achievements", app.getAchievementsHandler)
+
+	handle(http.MethodGet, "/v1/stats/powerlifting", app.getPowerliftingStatsHandler)
+	handle(http.MethodGet, "/v1/stats/load", app.getLoadStatsHandler)
+
+	handle(http.MethodGet, "/v1/insights/plateaus", app.getPlateausHandler)
+
+	handle(http.MethodGet, "/v1/tools/plates", app.getPlatesHandler)
+	handle(http.MethodGet, "/v1/tools/warmup", app.getWarmUpHandler)
+	return chain(router, app.requestID, app.instrument, app.trace, app.logRequests, app.recoverPanic, app.enableCORS, app.rateLimit, app.idempotency)
 }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// serve runs the HTTP server until SIGINT or SIGTERM, then stops accepting
+// requests, lets the in-flight ones finish and waits for the background jobs,
+// all within the shutdown timeout.
+func (app *application) serve() error {
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.config.port),
+		Handler:      app.routes(),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		ErrorLog:     log.New(app.logger, "", 0),
+	}
+
+	shutdownError := make(chan error)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+		app.logger.PrintInfo("shutting down server", map[string]string{"signal": s.String()})
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+		defer cancel()
+
+		close(app.shutdown)
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		app.logger.PrintInfo("waiting for background tasks to finish", nil)
+		shutdownError <- app.waitBackground(ctx)
+	}()
+
+	app.startCleanup()
+
+	app.logger.PrintInfo("starting server", map[string]string{"addr": srv.Addr, "env": app.config.env})
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("stopped server", map[string]string{"addr": srv.Addr})
+	return nil
+}
+
+// background runs fn in a goroutine that shutdown waits for. A panic in fn is
+// logged instead of taking the server down.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%v", err), map[string]string{"source": "background task"})
+			}
+		}()
+		fn()
+	}()
+}
+
+// waitBackground waits for the background tasks, or until ctx is done.
+func (app *application) waitBackground(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("background tasks still running: %w", ctx.Err())
+	}
+}
+
+// every runs fn every interval in a background task until the server shuts
+// down.
+func (app *application) every(interval time.Duration, fn func()) {
+	app.background(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.shutdown:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	})
+}
+
+// startCleanup starts the periodic cleanups. Expired idempotency keys are
+// otherwise only removed when they are reused, and the limiter would keep a
+// bucket for every client it has ever seen.
+func (app *application) startCleanup() {
+	if interval := app.config.idempotency.cleanupInterval; interval > 0 {
+		app.every(interval, func() {
+			deleted, err := app.models.IdempotencyModel.DeleteExpired(context.Background())
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"source": "idempotency cleanup"})
+				return
+			}
+			if deleted > 0 {
+				app.logger.PrintInfo("deleted expired idempotency keys", map[string]string{"count": strconv.FormatInt(deleted, 10)})
+			}
+		})
+	}
+
+	if app.limiter != nil {
+		app.every(limiterCleanupInterval, func() {
+			app.limiter.Cleanup(limiterIdleTime)
+		})
+	}
+}
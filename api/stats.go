@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"workout-microservice/internal/data"
+	"workout-microservice/internal/filters"
+	"workout-microservice/internal/scoring"
+	"workout-microservice/internal/training"
+	"workout-microservice/internal/validator"
+)
+
+// exercise names the three competition lifts are matched by when the caller
+// does not pass the exercise ids explicitly.
+var powerliftingLifts = []struct {
+	lift  string
+	param string
+	names []string
+}{
+	{lift: "squat", param: "squat_id", names: []string{"squat", "back squat"}},
+	{lift: "bench", param: "bench_id", names: []string{"bench press", "bench"}},
+	{lift: "deadlift", param: "deadlift_id", names: []string{"deadlift"}},
+}
+
+type powerliftingStats struct {
+	UserId     int            `json:"user_id"`
+	Bodyweight float64        `json:"bodyweight"`
+	Sex        string         `json:"sex"`
+	Lifts      map[string]int `json:"lifts"`
+	Total      int            `json:"total"`
+	Scores     scoring.Scores `json:"scores"`
+}
+
+func (app *application) getPowerliftingStatsHandler(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	v := validator.New()
+
+	userId := app.readInt(queryValues, userIdStr, 0, v)
+	v.Check(userId > 0, "user id", "should be > 0")
+	liftIds := make(map[string]int, len(powerliftingLifts))
+	for _, l := range powerliftingLifts {
+		liftIds[l.lift] = app.readInt(queryValues, l.param, 0, v)
+	}
+	if !v.Valid() {
+		app.errorResponse(w, r, http.StatusBadRequest, v.Errors)
+		return
+	}
+
+	stats := powerliftingStats{
+		UserId: userId,
+		Lifts:  make(map[string]int, len(powerliftingLifts)),
+	}
+
+	// the profile is only a default, bodyweight and sex can be passed to score
+	// "what if" scenarios such as a planned weight class change
+	profile, err := app.models.ProfileModel.Get(r.Context(), userId)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if profile != nil {
+		stats.Bodyweight = profile.Bodyweight
+		stats.Sex = profile.Sex
+	}
+	stats.Bodyweight = app.readFloat(queryValues, "bodyweight", stats.Bodyweight, v)
+	stats.Sex = app.readString(queryValues, "sex", stats.Sex)
+
+	v.Check(stats.Bodyweight > 0, "bodyweight", "must be set on the profile or passed as bodyweight=?")
+	v.Check(validator.In(stats.Sex, data.SexMale, data.SexFemale), "sex", "must be set on the profile or passed as sex=male|female")
+
+	prs, _, err := app.models.PrModel.GetAll(r.Context(), userId, filters.Filters{})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, l := range powerliftingLifts {
+		for _, pr := range prs {
+			if liftIds[l.lift] == pr.ExerciseId ||
+				(liftIds[l.lift] == 0 && validator.In(strings.ToLower(pr.ExerciseName), l.names...)) {
+				stats.Lifts[l.lift] = max(stats.Lifts[l.lift], pr.PersonalRecord)
+			}
+		}
+		v.Check(stats.Lifts[l.lift] > 0, l.lift, "no personal record found")
+		stats.Total += stats.Lifts[l.lift]
+	}
+
+	if !v.Valid() {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, v.Errors)
+		return
+	}
+
+	stats.Scores, err = scoring.All(float64(stats.Total), stats.Bodyweight, stats.Sex)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"powerlifting": stats}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getLoadStatsHandler(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	v := validator.New()
+
+	userId := app.readInt(queryValues, userIdStr, 0, v)
+	metric := app.readString(queryValues, "metric", training.MetricSessionRpe)
+	to := app.readTime(queryValues, "to", v)
+	from := app.readTime(queryValues, "from", v)
+	thresholds := training.Thresholds{
+		High: app.readFloat(queryValues, "acwr_high", app.config.load.acwrHigh, v),
+		Low:  app.readFloat(queryValues, "acwr_low", app.config.load.acwrLow, v),
+	}
+
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.AddDate(0, 0, -(training.ChronicDays - 1))
+	}
+
+	v.Check(userId > 0, "user id", "should be > 0")
+	v.Check(validator.In(metric, training.MetricSessionRpe, training.MetricVolume), "metric", "should be srpe or volume")
+	v.Check(!from.After(to), "from", "should not be after to")
+	v.Check(to.Sub(from) <= 366*24*time.Hour, "from", "range should not exceed a year")
+	v.Check(thresholds.Low >= 0 && thresholds.High >= thresholds.Low, "acwr_high", "should be >= acwr_low >= 0")
+	if !v.Valid() {
+		app.errorResponse(w, r, http.StatusBadRequest, v.Errors)
+		return
+	}
+
+	loads, err := app.models.WorkoutModel.DailyLoads(r.Context(), userId, training.HistoryStart(from), training.Truncate(to).AddDate(0, 0, 1))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	report, err := training.Compute(loads, from, to, metric, thresholds)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"user_id": userId, "load": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"workout-microservice/internal/data"
+	"workout-microservice/internal/plates"
+	"workout-microservice/internal/validator"
+)
+
+// readEquipment resolves the unit, bar and plate inventory for a tools request.
+// The unit and bar can be overridden with query parameters, everything else
+// comes from the profile of user_id (when given) or the gym defaults.
+func (app *application) readEquipment(ctx context.Context, qs url.Values, v *validator.Validator) (string, float64, []float64, error) {
+	userId := app.readInt(qs, userIdStr, 0, v)
+
+	var profile *data.Profile
+	if userId > 0 {
+		p, err := app.models.ProfileModel.Get(ctx, userId)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			return "", 0, nil, err
+		}
+		profile = p
+	}
+
+	unit := plates.UnitKg
+	if profile != nil {
+		unit = profile.Unit
+	}
+	unit = app.readString(qs, "unit", unit)
+	if !validator.In(unit, plates.UnitKg, plates.UnitLb) {
+		v.AddError("unit", "must be kg or lb")
+		return "", 0, nil, nil
+	}
+
+	bar, inventory, err := data.Equipment(profile, unit)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	bar = app.readFloat(qs, "bar", bar, v)
+	v.Check(bar >= 0, "bar", "must be >= 0")
+
+	return unit, bar, inventory, nil
+}
+
+func (app *application) getPlatesHandler(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	v := validator.New()
+
+	weight := app.readFloat(queryValues, "weight", 0, v)
+	v.Check(weight > 0, "weight", "must be > 0")
+
+	unit, bar, inventory, err := app.readEquipment(r.Context(), queryValues, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	v.Check(weight >= bar, "weight", "must not be lighter than the bar")
+	if !v.Valid() {
+		app.errorResponse(w, r, http.StatusBadRequest, v.Errors)
+		return
+	}
+
+	loadout, err := plates.Calculate(weight, bar, inventory)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"unit": unit, "plates": loadout}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getWarmUpHandler(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	v := validator.New()
+
+	exerciseId := app.readInt(queryValues, exerciseIdStr, 0, v)
+	userId := app.readInt(queryValues, userIdStr, 0, v)
+	target := app.readFloat(queryValues, "target", 0, v)
+	v.Check(exerciseId > 0, "exercise id", "must be > 0")
+	v.Check(target > 0 || userId > 0, "target", "must be > 0, or user_id given to warm up to the current personal record")
+	if !v.Valid() {
+		app.errorResponse(w, r, http.StatusBadRequest, v.Errors)
+		return
+	}
+
+	unit, bar, inventory, err := app.readEquipment(r.Context(), queryValues, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if target == 0 {
+		pr, err := app.models.PrModel.Get(r.Context(), userId, exerciseId)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				app.notFoundResponse(w, r, err)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		target = float64(pr.PersonalRecord)
+	}
+	v.Check(target >= bar, "target", "must not be lighter than the bar")
+	if !v.Valid() {
+		app.errorResponse(w, r, http.StatusBadRequest, v.Errors)
+		return
+	}
+
+	sets, err := plates.WarmUp(target, bar, inventory)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"exercise_id": exerciseId,
+		"unit":        unit,
+		"target":      target,
+		"warmup":      sets,
+	}
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"workout-microservice/internal/tracing"
+)
+
+// otlpEndpointDefault follows the environment variable of the OpenTelemetry
+// SDKs so the API can share their configuration.
+func otlpEndpointDefault() string {
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	return "http://localhost:4318"
+}
+
+// newTracerProvider returns the tracer provider for the configured exporter,
+// nil when tracing is disabled.
+func (app *application) newTracerProvider() (*sdktrace.TracerProvider, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch app.config.tracing.exporter {
+	case "none", "":
+		return nil, nil
+	case "stdout":
+		// the spans share stdout with the JSON log entries, a log collector
+		// that cannot tell them apart needs the file or otlp exporter
+		exporter, err = tracing.NewWriterExporter(os.Stdout)
+	case "file":
+		exporter, err = tracing.NewFileExporter(app.config.tracing.file)
+	case "otlp":
+		exporter, err = tracing.NewOTLPExporter(context.Background(), app.config.tracing.otlpEndpoint)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, use none, stdout, file or otlp", app.config.tracing.exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	onError := func(err error) {
+		app.logger.PrintError(err, map[string]string{"source": "tracing"})
+	}
+	return tracing.New(exporter, onError,
+		attribute.String("service.name", app.config.tracing.serviceName),
+		attribute.String("service.version", appVersion),
+		attribute.String("deployment.environment", app.config.env)), nil
+}
+
+// dbSystem is the OpenTelemetry db.system name of a database/sql driver.
+func dbSystem(driver string) string {
+	if driver == driverPostgres {
+		return "postgresql"
+	}
+	return driver
+}
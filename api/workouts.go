@@ -1,26 +1,31 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/julienschmidt/httprouter"
 	"net/http"
 	"strconv"
 	"workout-microservice/internal/data"
+	"workout-microservice/internal/filters"
 	"workout-microservice/internal/validator"
 )
 
 // TODO: Refactor code to make reusable functions
 
+var workoutSortSafelist = []string{"-created_at", "created_at", "workout_id", "exercise_id", "duration", "sets",
+	"-workout_id", "-exercise_id", "-duration", "-sets"}
+
 func (app *application) getWorkoutsHandler(w http.ResponseWriter, r *http.Request) {
 	queryValues := r.URL.Query()
 	if queryValues.Has("workout_id") {
 		workoutId, err := strconv.ParseInt(queryValues.Get("workout_id"), 10, 64)
 		if err != nil {
-			app.logger.Println("error occurred while parsing workout id", err)
 			app.badRequestResponse(w, r, err)
 			return
 		}
-		workouts, err := app.models.WorkoutModel.GetByWorkoutId(int(workoutId))
+		workouts, err := app.models.WorkoutModel.GetByWorkoutId(r.Context(), int(workoutId))
 		if err != nil {
 			if errors.Is(err, data.ErrRecordNotFound) {
 				app.badRequestResponse(w, r, errors.New("the requested workout does not exist"))
@@ -37,70 +42,54 @@ func (app *application) getWorkoutsHandler(w http.ResponseWriter, r *http.Reques
 		env := envelope{
 			"workout": workouts,
 		}
-		err = app.writeJSON(w, http.StatusOK, env, nil)
+		headers := make(http.Header)
+		headers.Set("ETag", etag(workouts[0].Version))
+
+		err = app.writeJSON(w, r, http.StatusOK, env, headers)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
-	} else if queryValues.Has("user_id") && queryValues.Has("exercise_id") {
+	} else if queryValues.Has("user_id") {
 		userId, err := strconv.ParseInt(queryValues.Get("user_id"), 10, 64)
 		if err != nil {
-			app.logger.Println("error occurred while parsing user id", err)
 			app.badRequestResponse(w, r, err)
 			return
 		}
 
-		exerciseId, err := strconv.ParseInt(queryValues.Get("exercise_id"), 10, 64)
-		if err != nil {
-			app.logger.Println("error occurred while parsing exercise id", err)
-			app.badRequestResponse(w, r, err)
-			return
+		var exerciseId int64
+		if queryValues.Has("exercise_id") {
+			exerciseId, err = strconv.ParseInt(queryValues.Get("exercise_id"), 10, 64)
+			if err != nil {
+				app.badRequestResponse(w, r, err)
+				return
+			}
 		}
 
-		workouts, err := app.models.WorkoutModel.GetByUserIdAndExerciseId(int(userId), int(exerciseId))
-		if err != nil {
-			app.badRequestResponse(w, r, err)
+		if queryValues.Has("cursor") {
+			app.getWorkoutsAfterCursor(w, r, int(userId), int(exerciseId))
 			return
 		}
 
-		if workouts == nil {
-			app.badRequestResponse(w, r, errors.New("no workouts found"))
+		v := validator.New()
+		f := app.readFilters(queryValues, workoutSortSafelist, v)
+		if !v.Valid() {
+			app.errorResponse(w, r, http.StatusBadRequest, v.Errors)
 			return
 		}
 
-		env := envelope{
-			"workout": workouts,
-		}
-
-		err = app.writeJSON(w, http.StatusOK, env, nil)
+		workouts, metadata, err := app.models.WorkoutModel.GetAll(r.Context(), int(userId), int(exerciseId), f)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
-	} else if queryValues.Has("user_id") {
-		userId, err := strconv.ParseInt(queryValues.Get("user_id"), 10, 64)
-		if err != nil {
-			app.logger.Println("error occurred while parsing user id", err)
-			app.badRequestResponse(w, r, err)
-			return
-		}
-
-		workouts, err := app.models.WorkoutModel.GetByUserId(int(userId))
-		if err != nil {
-			app.logger.Println(err)
-			app.serverErrorResponse(w, r, err)
-			return
-		}
-		if workouts == nil {
-			app.badRequestResponse(w, r, errors.New("no workouts found"))
-			return
-		}
 
 		env := envelope{
-			"workout": workouts,
+			"workout":  workouts,
+			"metadata": metadata,
 		}
 
-		err = app.writeJSON(w, http.StatusOK, env, nil)
+		err = app.writeJSON(w, r, http.StatusOK, env, nil)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -110,14 +99,66 @@ func (app *application) getWorkoutsHandler(w http.ResponseWriter, r *http.Reques
 	}
 }
 
+// getWorkoutsAfterCursor serves keyset pages of a user's workouts for clients
+// syncing their history. An empty cursor starts from the newest workout, or the
+// oldest one with sort=created_at, and every page returns the next_cursor to
+// continue from (null on the last page).
+func (app *application) getWorkoutsAfterCursor(w http.ResponseWriter, r *http.Request, userId, exerciseId int) {
+	queryValues := r.URL.Query()
+	v := validator.New()
+
+	f := filters.Filters{
+		PageSize: app.readInt(queryValues, "page_size", filters.DefaultPageSize, v),
+		Sort:     app.readString(queryValues, "sort", "-created_at"),
+		From:     app.readTime(queryValues, "from", v),
+		To:       app.readTime(queryValues, "to", v),
+	}
+	v.Check(f.PageSize > 0 && f.PageSize <= filters.MaxPageSize, "page_size", "must be between 1 and 100")
+	v.Check(validator.In(f.Sort, "created_at", "-created_at"), "sort", "must be created_at or -created_at when using a cursor")
+	v.Check(f.From.IsZero() || f.To.IsZero() || f.From.Before(f.To), "from", "must be before to")
+
+	cursor := filters.Cursor{Descending: f.Sort == "-created_at"}
+	if s := queryValues.Get("cursor"); s != "" {
+		var err error
+		cursor, err = filters.DecodeCursor(s)
+		v.Check(err == nil, "cursor", "is invalid, use the next_cursor of the previous page")
+	}
+	if !v.Valid() {
+		app.errorResponse(w, r, http.StatusBadRequest, v.Errors)
+		return
+	}
+
+	workouts, next, err := app.models.WorkoutModel.GetAllAfter(r.Context(), userId, exerciseId, f, cursor)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var nextCursor *string
+	if next != nil {
+		encoded := next.Encode()
+		nextCursor = &encoded
+	}
+
+	env := envelope{
+		"workout":     workouts,
+		"next_cursor": nextCursor,
+	}
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) addWorkoutHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		UserId     int   `json:"user_id"`
-		ExerciseId int   `json:"exercise_id"`
-		Duration   int   `json:"duration"`
-		Sets       int   `json:"sets"`
-		Reps       []int `json:"reps"`
-		Weights    []int `json:"weights"`
+		UserId     int     `json:"user_id"`
+		ExerciseId int     `json:"exercise_id"`
+		Duration   int     `json:"duration"`
+		Sets       int     `json:"sets"`
+		Reps       []int   `json:"reps"`
+		Weights    []int   `json:"weights"`
+		Rpe        float64 `json:"rpe"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -133,6 +174,7 @@ func (app *application) addWorkoutHandler(w http.ResponseWriter, r *http.Request
 		Sets:       input.Sets,
 		Reps:       input.Reps,
 		Weights:    input.Weights,
+		Rpe:        input.Rpe,
 	}
 
 	v := validator.New()
@@ -142,20 +184,32 @@ func (app *application) addWorkoutHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// added triggers for auto inserting if the no pr exists/current pr is exceeded when
-	// we insert a new workout into the workouts table
-
-	err = app.models.WorkoutModel.Insert(&workout)
+	prChanges, earned, err := app.models.WorkoutModel.Insert(r.Context(), &workout)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.metrics.recordWorkouts(1, prChanges)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/workouts?workout_id=%d", workout.WorkoutId))
+	headers.Set("ETag", etag(workout.Version))
+
+	env := envelope{
+		"workout":      workout,
+		"pr_changes":   prChanges,
+		"achievements": app.describeAchievements(earned),
+	}
+	err = app.writeJSON(w, r, http.StatusCreated, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
 func (app *application) deleteWorkoutHandler(w http.ResponseWriter, r *http.Request) {
 	workoutId, err := app.readWorkoutIDParams(r)
 	if err != nil {
-		app.logger.Println(err)
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
@@ -164,23 +218,54 @@ func (app *application) deleteWorkoutHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = app.models.WorkoutModel.Delete(workoutId)
+	existing, err := app.getWorkout(r.Context(), workoutId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.checkIfMatch(r, existing.Version)
 	if err != nil {
-		app.logger.Println(err)
+		app.preconditionResponse(w, r, err)
 		return
 	}
-	return
+
+	prChanges, err := app.models.WorkoutModel.Delete(r.Context(), workoutId, existing.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"message":    fmt.Sprintf("Workout with id %d deleted successfully", workoutId),
+		"pr_changes": prChanges,
+	}
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
 func (app *application) UpdateWorkoutHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		WorkoutId  int   `json:"workout_id"`
-		UserId     int   `json:"user_id"`
-		ExerciseId int   `json:"exercise_id"`
-		Duration   int   `json:"duration"`
-		Sets       int   `json:"sets"`
-		Reps       []int `json:"reps"`
-		Weights    []int `json:"weights"`
+		WorkoutId  int     `json:"workout_id"`
+		UserId     int     `json:"user_id"`
+		ExerciseId int     `json:"exercise_id"`
+		Duration   int     `json:"duration"`
+		Sets       int     `json:"sets"`
+		Reps       []int   `json:"reps"`
+		Weights    []int   `json:"weights"`
+		Rpe        float64 `json:"rpe"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -197,6 +282,7 @@ func (app *application) UpdateWorkoutHandler(w http.ResponseWriter, r *http.Requ
 		Sets:       input.Sets,
 		Reps:       input.Reps,
 		Weights:    input.Weights,
+		Rpe:        input.Rpe,
 	}
 
 	v := validator.New()
@@ -206,14 +292,65 @@ func (app *application) UpdateWorkoutHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// added trigger for update as well. Have to test it
+	existing, err := app.getWorkout(r.Context(), workout.WorkoutId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if existing.UserId != workout.UserId {
+		app.notFoundResponse(w, r, fmt.Errorf("workout %d does not belong to user %d", workout.WorkoutId, workout.UserId))
+		return
+	}
 
-	err = app.models.WorkoutModel.Update(&workout)
+	err = app.checkIfMatch(r, existing.Version)
 	if err != nil {
-		app.logger.Println("error while updating row for workouts")
-		app.serverErrorResponse(w, r, err)
+		app.preconditionResponse(w, r, err)
 		return
 	}
+	workout.Version = existing.Version
+	workout.CreatedAt = existing.CreatedAt
+
+	prChanges, earned, err := app.models.WorkoutModel.Update(r.Context(), &workout)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.metrics.recordWorkouts(0, prChanges)
+
+	env := envelope{
+		"workout":      workout,
+		"pr_changes":   prChanges,
+		"achievements": app.describeAchievements(earned),
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", etag(workout.Version))
+
+	err = app.writeJSON(w, r, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getWorkout returns a single workout, or data.ErrRecordNotFound.
+func (app *application) getWorkout(ctx context.Context, workoutId int) (*data.Workout, error) {
+	workouts, err := app.models.WorkoutModel.GetByWorkoutId(ctx, workoutId)
+	if err != nil {
+		return nil, err
+	}
+	if len(workouts) == 0 {
+		return nil, data.ErrRecordNotFound
+	}
+	return workouts[0], nil
 }
 
 func (app *application) readWorkoutIDParams(r *http.Request) (int, error) {
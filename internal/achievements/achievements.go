@@ -0,0 +1,173 @@
+package achievements
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+	"workout-microservice/internal/validator"
+)
+
+const (
+	TypeWorkoutCount = "workout_count"
+	TypeWeeklyStreak = "weekly_streak"
+	TypeMaxWeight    = "max_weight"
+	TypeTotalVolume  = "total_volume"
+)
+
+//go:embed rules.json
+var defaultRules []byte
+
+// Rule declares a single badge. New badges only need a new entry in the rules
+// file, the type decides which statistic of the user is compared to the threshold.
+type Rule struct {
+	Code         string  `json:"code"`
+	Name         string  `json:"name"`
+	Description  string  `json:"description"`
+	Type         string  `json:"type"`
+	ExerciseName string  `json:"exercise_name,omitempty"`
+	Threshold    float64 `json:"threshold"`
+}
+
+// Stats is the snapshot of a user's training history the rules are evaluated
+// against. Exercise names in MaxWeights are lower case.
+type Stats struct {
+	WorkoutCount int
+	TotalVolume  int
+	WeeklyStreak int
+	MaxWeights   map[string]int
+	// Weeks holds the start of every week with a workout in ascending order,
+	// so that the week a streak was reached in can be found.
+	Weeks []time.Time
+}
+
+type Progress struct {
+	Rule     Rule    `json:"rule"`
+	Current  float64 `json:"current"`
+	Target   float64 `json:"target"`
+	Percent  float64 `json:"percent"`
+	Achieved bool    `json:"achieved"`
+}
+
+func ValidateRule(v *validator.Validator, rule *Rule) bool {
+	v.Check(rule.Code != "", "code", "cannot be empty")
+	v.Check(rule.Name != "", rule.Code+" name", "cannot be empty")
+	v.Check(validator.In(rule.Type, TypeWorkoutCount, TypeWeeklyStreak, TypeMaxWeight, TypeTotalVolume),
+		rule.Code+" type", "is not a known rule type")
+	v.Check(rule.Threshold > 0, rule.Code+" threshold", "should be > 0")
+	if rule.Type == TypeMaxWeight {
+		v.Check(rule.ExerciseName != "", rule.Code+" exercise name", "is required for max_weight rules")
+	}
+	return v.Valid()
+}
+
+// LoadRules reads and validates a JSON array of rules.
+func LoadRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	err := json.NewDecoder(r).Decode(&rules)
+	if err != nil {
+		return nil, fmt.Errorf("decoding achievement rules: %w", err)
+	}
+
+	v := validator.New()
+	codes := make([]string, 0, len(rules))
+	for i := range rules {
+		ValidateRule(v, &rules[i])
+		codes = append(codes, rules[i].Code)
+	}
+	v.Check(validator.Unique(codes), "code", "must be unique")
+	if !v.Valid() {
+		return nil, fmt.Errorf("invalid achievement rules: %v", v.Errors)
+	}
+	return rules, nil
+}
+
+// LoadRulesFile loads the rules at path, falling back to the embedded defaults
+// when path is empty.
+func LoadRulesFile(path string) ([]Rule, error) {
+	if path == "" {
+		return LoadRules(strings.NewReader(string(defaultRules)))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadRules(f)
+}
+
+func (r Rule) current(s Stats) float64 {
+	switch r.Type {
+	case TypeWorkoutCount:
+		return float64(s.WorkoutCount)
+	case TypeWeeklyStreak:
+		return float64(s.WeeklyStreak)
+	case TypeMaxWeight:
+		return float64(s.MaxWeights[strings.ToLower(r.ExerciseName)])
+	case TypeTotalVolume:
+		return float64(s.TotalVolume)
+	default:
+		return 0
+	}
+}
+
+// Evaluate returns the progress of every rule against the given stats.
+func Evaluate(rules []Rule, s Stats) []Progress {
+	progress := make([]Progress, 0, len(rules))
+	for _, rule := range rules {
+		current := rule.current(s)
+		p := Progress{
+			Rule:     rule,
+			Current:  current,
+			Target:   rule.Threshold,
+			Percent:  math.Min(100, math.Round(current/rule.Threshold*1000)/10),
+			Achieved: current >= rule.Threshold,
+		}
+		progress = append(progress, p)
+	}
+	return progress
+}
+
+// WeekStart truncates t to the Monday of its week in UTC.
+func WeekStart(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
+
+// LongestWeeklyStreak counts the longest run of consecutive weeks in weeks,
+// which must hold the start of each week sorted in ascending order.
+func LongestWeeklyStreak(weeks []time.Time) int {
+	longest, current := 0, 0
+	for i := range weeks {
+		if i > 0 && weeks[i].Sub(weeks[i-1]) <= 8*24*time.Hour {
+			current++
+		} else {
+			current = 1
+		}
+		longest = max(longest, current)
+	}
+	return longest
+}
+
+// StreakWeek returns the week of weeks, sorted in ascending order, with which
+// the user first trained n consecutive weeks.
+func StreakWeek(weeks []time.Time, n int) (time.Time, bool) {
+	current := 0
+	for i := range weeks {
+		if i > 0 && weeks[i].Sub(weeks[i-1]) <= 8*24*time.Hour {
+			current++
+		} else {
+			current = 1
+		}
+		if current >= n {
+			return weeks[i], true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,227 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"workout-microservice/internal/achievements"
+)
+
+// the number of workouts of the user and the weight they moved in them
+const selectAchievementTotalsQuery = `SELECT count(*),
+COALESCE(SUM((SELECT SUM(s.weight * s.reps) FROM unnest(weights, reps) AS s(weight, reps))), 0)
+FROM workouts_table WHERE user_id = $1;`
+
+// the heaviest weight of every exercise, which is its PR
+const selectAchievementMaxWeightsQuery = `SELECT lower(exercises.exercise_name), COALESCE(MAX(exercise_prs.pr), 0)
+FROM exercise_prs JOIN exercises ON exercise_prs.exercise_id = exercises.exercise_id
+WHERE exercise_prs.user_id = $1
+GROUP BY lower(exercises.exercise_name);`
+
+const selectAchievementWeeksQuery = `SELECT DISTINCT date_trunc('week', created_at, 'UTC') AS week
+FROM workouts_table WHERE user_id = $1
+ORDER BY week;`
+
+const selectNthWorkoutQuery = `SELECT workout_id FROM workouts_table WHERE user_id = $1
+ORDER BY created_at, workout_id
+LIMIT 1 OFFSET $2;`
+
+// the workout with which the running total of the volume reached $2
+const selectVolumeReachedQuery = `SELECT workout_id FROM (
+	SELECT workout_id, created_at, SUM((SELECT SUM(s.weight * s.reps) FROM unnest(weights, reps) AS s(weight, reps)))
+		OVER (ORDER BY created_at, workout_id) AS volume
+	FROM workouts_table WHERE user_id = $1
+) AS running
+WHERE volume >= $2
+ORDER BY created_at, workout_id
+LIMIT 1;`
+
+const selectWeightReachedQuery = `SELECT workouts_table.workout_id
+FROM workouts_table JOIN exercises ON workouts_table.exercise_id = exercises.exercise_id
+WHERE workouts_table.user_id = $1 AND lower(exercises.exercise_name) = lower($2) AND $3 <= ANY(workouts_table.weights)
+ORDER BY workouts_table.created_at, workouts_table.workout_id
+LIMIT 1;`
+
+const selectFirstWorkoutSinceQuery = `SELECT workout_id FROM workouts_table WHERE user_id = $1 AND created_at >= $2
+ORDER BY created_at, workout_id
+LIMIT 1;`
+
+const selectEarnedCodesQuery = `SELECT code FROM achievements WHERE user_id = $1;`
+
+const insertAchievementQuery = `INSERT INTO achievements (user_id, code, workout_id) VALUES ($1, $2, $3)
+ON CONFLICT (user_id, code) DO NOTHING
+RETURNING earned_at;`
+
+const selectAchievementsQuery = `SELECT user_id, code, workout_id, earned_at FROM achievements
+WHERE user_id = $1 ORDER BY earned_at, code;`
+
+// EarnedAchievement is a badge persisted for a user together with the workout
+// that unlocked it.
+type EarnedAchievement struct {
+	UserId    int       `json:"user_id"`
+	Code      string    `json:"code"`
+	WorkoutId int       `json:"workout_id,omitempty"`
+	EarnedAt  time.Time `json:"earned_at"`
+}
+
+type AchievementModel struct {
+	db       *sql.DB
+	timeouts Timeouts
+}
+
+// Stats collects the statistics the achievement rules are evaluated against.
+func (a AchievementModel) Stats(ctx context.Context, userId int) (achievements.Stats, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Read)
+	defer cancel()
+
+	return sqlAchievementStore{q: a.db}.stats(ctx, userId)
+}
+
+func (a AchievementModel) GetAll(ctx context.Context, userId int) ([]EarnedAchievement, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Read)
+	defer cancel()
+
+	rows, err := a.db.QueryContext(ctx, selectAchievementsQuery, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	earned := []EarnedAchievement{}
+	for rows.Next() {
+		var achievement EarnedAchievement
+		var workoutId sql.NullInt64
+		err = rows.Scan(&achievement.UserId, &achievement.Code, &workoutId, &achievement.EarnedAt)
+		if err != nil {
+			return nil, err
+		}
+		achievement.WorkoutId = int(workoutId.Int64)
+		earned = append(earned, achievement)
+	}
+	return earned, rows.Err()
+}
+
+// sqlAchievementStore is the achievementStore of the Postgres backend.
+type sqlAchievementStore struct {
+	q dbtx
+}
+
+func newAchievementService(q dbtx, rules []achievements.Rule) achievementService {
+	return achievementService{store: sqlAchievementStore{q: q}, rules: rules}
+}
+
+func (s sqlAchievementStore) stats(ctx context.Context, userId int) (achievements.Stats, error) {
+	stats := achievements.Stats{}
+	err := s.q.QueryRowContext(ctx, selectAchievementTotalsQuery, userId).Scan(&stats.WorkoutCount, &stats.TotalVolume)
+	if err != nil {
+		return achievements.Stats{}, err
+	}
+
+	stats.MaxWeights, err = queryMaxWeights(ctx, s.q, selectAchievementMaxWeightsQuery, userId)
+	if err != nil {
+		return achievements.Stats{}, err
+	}
+
+	rows, err := s.q.QueryContext(ctx, selectAchievementWeeksQuery, userId)
+	if err != nil {
+		return achievements.Stats{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var week time.Time
+		if err = rows.Scan(&week); err != nil {
+			return achievements.Stats{}, err
+		}
+		stats.Weeks = append(stats.Weeks, week.UTC())
+	}
+	if err = rows.Err(); err != nil {
+		return achievements.Stats{}, err
+	}
+
+	stats.WeeklyStreak = achievements.LongestWeeklyStreak(stats.Weeks)
+	return stats, nil
+}
+
+func (s sqlAchievementStore) earned(ctx context.Context, userId int) (map[string]bool, error) {
+	return queryEarnedCodes(ctx, s.q, selectEarnedCodesQuery, userId)
+}
+
+func (s sqlAchievementStore) nthWorkout(ctx context.Context, userId, n int) (int, error) {
+	return queryWorkoutId(ctx, s.q, selectNthWorkoutQuery, userId, n-1)
+}
+
+func (s sqlAchievementStore) volumeReached(ctx context.Context, userId, volume int) (int, error) {
+	return queryWorkoutId(ctx, s.q, selectVolumeReachedQuery, userId, volume)
+}
+
+func (s sqlAchievementStore) weightReached(ctx context.Context, userId int, exerciseName string, weight int) (int, error) {
+	return queryWorkoutId(ctx, s.q, selectWeightReachedQuery, userId, exerciseName, weight)
+}
+
+func (s sqlAchievementStore) firstWorkoutSince(ctx context.Context, userId int, since time.Time) (int, error) {
+	return queryWorkoutId(ctx, s.q, selectFirstWorkoutSinceQuery, userId, since)
+}
+
+func (s sqlAchievementStore) award(ctx context.Context, userId int, code string, workoutId int) (*EarnedAchievement, error) {
+	achievement := EarnedAchievement{UserId: userId, Code: code, WorkoutId: workoutId}
+	workout := sql.NullInt64{Int64: int64(workoutId), Valid: workoutId > 0}
+	err := s.q.QueryRowContext(ctx, insertAchievementQuery, userId, code, workout).Scan(&achievement.EarnedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// earned before
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &achievement, nil
+}
+
+// queryWorkoutId runs a query selecting at most one workout id, 0 when it
+// selects none.
+func queryWorkoutId(ctx context.Context, q dbtx, query string, args ...interface{}) (int, error) {
+	var workoutId int
+	err := q.QueryRowContext(ctx, query, args...).Scan(&workoutId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return workoutId, err
+}
+
+func queryMaxWeights(ctx context.Context, q dbtx, query string, userId int) (map[string]int, error) {
+	rows, err := q.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	weights := make(map[string]int)
+	for rows.Next() {
+		var name string
+		var weight int
+		if err = rows.Scan(&name, &weight); err != nil {
+			return nil, err
+		}
+		weights[name] = weight
+	}
+	return weights, rows.Err()
+}
+
+func queryEarnedCodes(ctx context.Context, q dbtx, query string, userId int) (map[string]bool, error) {
+	rows, err := q.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := make(map[string]bool)
+	for rows.Next() {
+		var code string
+		if err = rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		codes[code] = true
+	}
+	return codes, rows.Err()
+}
@@ -0,0 +1,98 @@
+package data
+
+import (
+	"context"
+	"math"
+	"slices"
+	"time"
+	"workout-microservice/internal/achievements"
+)
+
+// achievementStore is the storage the achievement service works against. The
+// statistics are aggregated by the store, and the workout that first reached
+// a threshold is only looked up for the badges that are about to be awarded.
+type achievementStore interface {
+	stats(ctx context.Context, userId int) (achievements.Stats, error)
+	earned(ctx context.Context, userId int) (map[string]bool, error)
+	// nthWorkout, volumeReached, weightReached and firstWorkoutSince return 0
+	// when no workout qualifies.
+	nthWorkout(ctx context.Context, userId, n int) (int, error)
+	volumeReached(ctx context.Context, userId, volume int) (int, error)
+	weightReached(ctx context.Context, userId int, exerciseName string, weight int) (int, error)
+	firstWorkoutSince(ctx context.Context, userId int, since time.Time) (int, error)
+	// award returns nil when the user had already earned the badge.
+	award(ctx context.Context, userId int, code string, workoutId int) (*EarnedAchievement, error)
+}
+
+// achievementService awards the badges of the achievement rules. It runs in
+// the transaction that wrote the workouts, so a badge is saved together with
+// the workouts that earned it or not at all.
+type achievementService struct {
+	store achievementStore
+	rules []achievements.Rule
+}
+
+// Award evaluates the rules for every user and saves the badges they newly
+// met, each with the workout with which its threshold was first reached.
+func (s achievementService) Award(ctx context.Context, userIds ...int) ([]EarnedAchievement, error) {
+	earned := []EarnedAchievement{}
+	if len(s.rules) == 0 {
+		return earned, nil
+	}
+
+	userIds = slices.Clone(userIds)
+	slices.Sort(userIds)
+	for _, userId := range slices.Compact(userIds) {
+		stats, err := s.store.stats(ctx, userId)
+		if err != nil {
+			return nil, err
+		}
+		before, err := s.store.earned(ctx, userId)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, progress := range achievements.Evaluate(s.rules, stats) {
+			if !progress.Achieved || before[progress.Rule.Code] {
+				continue
+			}
+
+			workoutId, err := s.qualifying(ctx, userId, progress.Rule, stats)
+			if err != nil {
+				return nil, err
+			}
+			achievement, err := s.store.award(ctx, userId, progress.Rule.Code, workoutId)
+			if err != nil {
+				return nil, err
+			}
+			if achievement != nil {
+				earned = append(earned, *achievement)
+			}
+		}
+	}
+	return earned, nil
+}
+
+// qualifying returns the workout with which the statistic of the rule first
+// reached its threshold. Every statistic is a whole number, so reaching the
+// threshold means reaching it rounded up.
+func (s achievementService) qualifying(ctx context.Context, userId int, rule achievements.Rule, stats achievements.Stats) (int, error) {
+	threshold := int(math.Ceil(rule.Threshold))
+
+	switch rule.Type {
+	case achievements.TypeWorkoutCount:
+		return s.store.nthWorkout(ctx, userId, threshold)
+	case achievements.TypeTotalVolume:
+		return s.store.volumeReached(ctx, userId, threshold)
+	case achievements.TypeMaxWeight:
+		return s.store.weightReached(ctx, userId, rule.ExerciseName, threshold)
+	case achievements.TypeWeeklyStreak:
+		week, ok := achievements.StreakWeek(stats.Weeks, threshold)
+		if !ok {
+			return 0, nil
+		}
+		return s.store.firstWorkoutSince(ctx, userId, week)
+	default:
+		return 0, nil
+	}
+}
@@ -0,0 +1,184 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+)
+
+// the exercises of a batch that do not exist, checked up front since a failed
+// insert aborts the whole transaction
+const selectMissingExercisesQuery = `SELECT id FROM unnest($1::bigint[]) AS id
+WHERE NOT EXISTS (SELECT 1 FROM exercises WHERE exercise_id = id);`
+
+// MaxBatchSize is the most workouts accepted by a single InsertBatch call.
+const MaxBatchSize = 1000
+
+// BatchItemError reports which workout of a batch could not be inserted.
+type BatchItemError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchItemError) Error() string {
+	return fmt.Sprintf("workout %d: %s", e.Index, e.Err)
+}
+
+func (e *BatchItemError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError holds every workout of a batch that was rejected because of its
+// data. Other errors abort the batch straight away and are returned as is.
+type BatchError struct {
+	Items []*BatchItemError
+}
+
+func (e *BatchError) Error() string {
+	if len(e.Items) == 1 {
+		return e.Items[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more)", e.Items[0], len(e.Items)-1)
+}
+
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Items))
+	for _, item := range e.Items {
+		errs = append(errs, item)
+	}
+	return errs
+}
+
+// add records a rejected workout, it returns false when err is not caused by
+// the data of the workout and the batch has to be aborted.
+func (e *BatchError) add(index int, err error) bool {
+	if !errors.Is(err, ErrConstraintViolation) {
+		return false
+	}
+	e.Items = append(e.Items, &BatchItemError{Index: index, Err: err})
+	return true
+}
+
+// err returns e when a workout was rejected and nil otherwise.
+func (e *BatchError) err() error {
+	if len(e.Items) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ErrConstraintViolation wraps database errors caused by the data of a
+// workout, such as referencing an exercise that does not exist.
+var ErrConstraintViolation = errors.New("constraint violation")
+
+// PrKey identifies the personal record of a user for an exercise.
+type PrKey struct {
+	UserId     int `json:"user_id"`
+	ExerciseId int `json:"exercise_id"`
+}
+
+// InsertBatch inserts all workouts in a single transaction, either all of them
+// are saved or none. Each affected (user, exercise) PR is recomputed once after
+// all rows are written and the PRs that changed are returned, together with
+// the badges the owners of the workouts earned. When workouts are rejected
+// the returned error is a *BatchError. Workouts referencing a missing
+// exercise are all reported, since that is checked before anything is
+// written; any other constraint violation stops the batch at that workout.
+func (w WorkoutModel) InsertBatch(ctx context.Context, workouts []*Workout) ([]PrChange, []EarnedAchievement, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeouts.Batch)
+	defer cancel()
+
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	batchErr, err := checkBatchExercises(ctx, tx, workouts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = batchErr.err(); err != nil {
+		return nil, nil, err
+	}
+
+	affected := make([]PrKey, 0, len(workouts))
+	userIds := make([]int, 0, len(workouts))
+
+	for i, workout := range workouts {
+		args := []interface{}{
+			workout.UserId,
+			workout.ExerciseId,
+			workout.Duration,
+			workout.Sets,
+			pq.Array(workout.Reps),
+			pq.Array(workout.Weights),
+			nullRpe(workout.Rpe),
+			sql.NullTime{Time: workout.CreatedAt, Valid: !workout.CreatedAt.IsZero()},
+		}
+
+		err = tx.QueryRowContext(ctx, insertWorkoutQuery, args...).Scan(&workout.WorkoutId, &workout.CreatedAt, &workout.Version)
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code.Class() == "23" {
+				err = fmt.Errorf("%w: %s", ErrConstraintViolation, pqErr.Message)
+			}
+			if batchErr.add(i, err) {
+				return nil, nil, &batchErr
+			}
+			return nil, nil, &BatchItemError{Index: i, Err: err}
+		}
+
+		affected = append(affected, PrKey{UserId: workout.UserId, ExerciseId: workout.ExerciseId})
+		userIds = append(userIds, workout.UserId)
+	}
+
+	changes, err := newPrService(tx).Recompute(ctx, affected...)
+	if err != nil {
+		return nil, nil, err
+	}
+	earned, err := newAchievementService(tx, w.rules).Award(ctx, userIds...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return changes, earned, tx.Commit()
+}
+
+// checkBatchExercises reports every workout of the batch whose exercise does
+// not exist.
+func checkBatchExercises(ctx context.Context, tx *sql.Tx, workouts []*Workout) (BatchError, error) {
+	var batchErr BatchError
+
+	ids := make([]int64, 0, len(workouts))
+	for _, workout := range workouts {
+		ids = append(ids, int64(workout.ExerciseId))
+	}
+
+	rows, err := tx.QueryContext(ctx, selectMissingExercisesQuery, pq.Array(ids))
+	if err != nil {
+		return batchErr, err
+	}
+	defer rows.Close()
+
+	missing := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return batchErr, err
+		}
+		missing[id] = true
+	}
+	if err = rows.Err(); err != nil {
+		return batchErr, err
+	}
+
+	for i, workout := range workouts {
+		if missing[workout.ExerciseId] {
+			batchErr.add(i, fmt.Errorf("%w: exercise %d does not exist", ErrConstraintViolation, workout.ExerciseId))
+		}
+	}
+	return batchErr, nil
+}
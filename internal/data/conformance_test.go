@@ -0,0 +1,605 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"workout-microservice/internal/achievements"
+	"workout-microservice/internal/filters"
+	"workout-microservice/internal/jsonlog"
+	"workout-microservice/internal/migrate"
+	"workout-microservice/migrations"
+)
+
+// The conformance tests run the same scenarios against every storage backend,
+// so that memory and SQLite keep behaving like Postgres. Postgres only runs
+// when WORKOUT_TEST_DB_DSN points at a database the tests may wipe.
+
+type conformanceBackend struct {
+	name string
+	open func(t *testing.T) Models
+}
+
+var conformanceBackends = []conformanceBackend{
+	{name: "memory", open: openMemoryModels},
+	{name: "sqlite", open: openSQLiteModels},
+	{name: "postgres", open: openPostgresModels},
+}
+
+func openMemoryModels(t *testing.T) Models {
+	return NewMemoryModels(testRules(t))
+}
+
+func openSQLiteModels(t *testing.T) Models {
+	path := filepath.Join(t.TempDir(), "workouts.db")
+	// the same pragmas the api opens its database with
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrate.NewSQLite(db, migrations.SQLiteFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = migrator.Up(context.Background()); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	return NewSQLiteModels(db, DefaultTimeouts, testLogger(), testRules(t))
+}
+
+func openPostgresModels(t *testing.T) Models {
+	dsn := os.Getenv("WORKOUT_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("WORKOUT_TEST_DB_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = migrator.Up(context.Background()); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	_, err = db.Exec(`TRUNCATE workouts_table, exercises, exercise_prs, user_profiles, achievements, idempotency_keys RESTART IDENTITY CASCADE;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewModels(db, DefaultTimeouts, testLogger(), testRules(t))
+}
+
+func testLogger() *jsonlog.Logger {
+	return jsonlog.New(os.Stderr, jsonlog.LevelOff, jsonlog.FormatJSON)
+}
+
+// testRules are the built-in achievement rules.
+func testRules(t *testing.T) []achievements.Rule {
+	rules, err := achievements.LoadRulesFile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestConformance(t *testing.T) {
+	scenarios := []struct {
+		name string
+		run  func(t *testing.T, models Models)
+	}{
+		{"exercise CRUD and version conflicts", testExerciseCRUD},
+		{"workout CRUD and version conflicts", testWorkoutCRUD},
+		{"PRs are raised, lowered and removed", testPrLifecycle},
+		{"offset paging", testOffsetPaging},
+		{"cursor paging", testCursorPaging},
+		{"batch insert", testBatchInsert},
+		{"batch insert is all or nothing", testBatchInsertFailure},
+		{"achievements are awarded with the workout that earned them", testAchievementAwards},
+		{"e1rm leaderboard ranks the e1rm kept with the PR", testE1rmLeaderboard},
+		{"profile plates default to an empty list", testProfilePlates},
+	}
+
+	for _, backend := range conformanceBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			for _, scenario := range scenarios {
+				t.Run(scenario.name, func(t *testing.T) {
+					scenario.run(t, backend.open(t))
+				})
+			}
+		})
+	}
+}
+
+func testExerciseCRUD(t *testing.T, models Models) {
+	ctx := context.Background()
+
+	exercise := Exercise{ExerciseName: "Bench press", ExerciseDescription: "Barbell, flat bench"}
+	if err := models.ExerciseModel.Insert(ctx, &exercise); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	if exercise.ExerciseID < 1 || exercise.ExerciseVersion != 1 {
+		t.Fatalf("inserted exercise %+v, want an id and version 1", exercise)
+	}
+
+	got, err := models.ExerciseModel.Select(ctx, exercise.ExerciseID)
+	if err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	if *got != exercise {
+		t.Errorf("Select = %+v, want %+v", *got, exercise)
+	}
+
+	stale := exercise
+	exercise.ExerciseDescription = "Barbell, incline bench"
+	if err = models.ExerciseModel.Update(ctx, &exercise); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if exercise.ExerciseVersion != 2 {
+		t.Errorf("version after update = %d, want 2", exercise.ExerciseVersion)
+	}
+
+	stale.ExerciseName = "Stale"
+	if err = models.ExerciseModel.Update(ctx, &stale); !errors.Is(err, ErrEditConflict) {
+		t.Errorf("Update at a stale version = %v, want ErrEditConflict", err)
+	}
+	if err = models.ExerciseModel.Delete(ctx, exercise.ExerciseID, 1); !errors.Is(err, ErrEditConflict) {
+		t.Errorf("Delete at a stale version = %v, want ErrEditConflict", err)
+	}
+
+	if err = models.ExerciseModel.Delete(ctx, exercise.ExerciseID, exercise.ExerciseVersion); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err = models.ExerciseModel.Select(ctx, exercise.ExerciseID); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("Select after delete = %v, want ErrRecordNotFound", err)
+	}
+}
+
+func testWorkoutCRUD(t *testing.T, models Models) {
+	ctx := context.Background()
+	exerciseId := insertTestExercise(t, models, "Squat")
+
+	workout := testWorkout(1, exerciseId, time.Time{}, 100, 110)
+	if _, _, err := models.WorkoutModel.Insert(ctx, workout); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	if workout.WorkoutId < 1 || workout.Version != 1 || workout.CreatedAt.IsZero() {
+		t.Fatalf("inserted workout %+v, want an id, version 1 and a creation time", *workout)
+	}
+
+	got := getTestWorkout(t, models, workout.WorkoutId)
+	if got.UserId != 1 || got.ExerciseId != exerciseId || len(got.Weights) != 2 || got.Weights[1] != 110 {
+		t.Errorf("GetByWorkoutId = %+v, want %+v", *got, *workout)
+	}
+
+	stale := *workout
+	workout.Weights = []int{100, 115}
+	if _, _, err := models.WorkoutModel.Update(ctx, workout); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if workout.Version != 2 {
+		t.Errorf("version after update = %d, want 2", workout.Version)
+	}
+	if got = getTestWorkout(t, models, workout.WorkoutId); got.Weights[1] != 115 {
+		t.Errorf("weights after update = %v, want [100 115]", got.Weights)
+	}
+
+	if _, _, err := models.WorkoutModel.Update(ctx, &stale); !errors.Is(err, ErrEditConflict) {
+		t.Errorf("Update at a stale version = %v, want ErrEditConflict", err)
+	}
+	if _, err := models.WorkoutModel.Delete(ctx, workout.WorkoutId, 1); !errors.Is(err, ErrEditConflict) {
+		t.Errorf("Delete at a stale version = %v, want ErrEditConflict", err)
+	}
+
+	if _, err := models.WorkoutModel.Delete(ctx, workout.WorkoutId, workout.Version); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	workouts, err := models.WorkoutModel.GetByWorkoutId(ctx, workout.WorkoutId)
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("GetByWorkoutId after delete: %v", err)
+	}
+	if len(workouts) != 0 {
+		t.Errorf("GetByWorkoutId after delete = %v, want nothing", workouts)
+	}
+}
+
+func testPrLifecycle(t *testing.T, models Models) {
+	ctx := context.Background()
+	exerciseId := insertTestExercise(t, models, "Deadlift")
+	key := PrKey{UserId: 1, ExerciseId: exerciseId}
+
+	first := testWorkout(1, exerciseId, testDay(1), 140, 150)
+	changes, _, err := models.WorkoutModel.Insert(ctx, first)
+	if err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	assertPrChanges(t, changes, []PrChange{{PrKey: key, Previous: 0, Current: 150, AchievedAt: ptr(testDay(1))}})
+
+	second := testWorkout(1, exerciseId, testDay(2), 160)
+	changes, _, err = models.WorkoutModel.Insert(ctx, second)
+	if err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	assertPrChanges(t, changes, []PrChange{{PrKey: key, Previous: 150, Current: 160, AchievedAt: ptr(testDay(2))}})
+	assertPr(t, models, key, 160, testDay(2))
+
+	second.Weights = []int{145}
+	changes, _, err = models.WorkoutModel.Update(ctx, second)
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	assertPrChanges(t, changes, []PrChange{{PrKey: key, Previous: 160, Current: 150, AchievedAt: ptr(testDay(1))}})
+	assertPr(t, models, key, 150, testDay(1))
+
+	changes, err = models.WorkoutModel.Delete(ctx, first.WorkoutId, first.Version)
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	assertPrChanges(t, changes, []PrChange{{PrKey: key, Previous: 150, Current: 145, AchievedAt: ptr(testDay(2))}})
+
+	changes, err = models.WorkoutModel.Delete(ctx, second.WorkoutId, second.Version)
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	assertPrChanges(t, changes, []PrChange{{PrKey: key, Previous: 145, Current: 0}})
+	if _, err = models.PrModel.Get(ctx, key.UserId, key.ExerciseId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Get after the last workout was deleted = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testOffsetPaging(t *testing.T, models Models) {
+	ctx := context.Background()
+	exerciseId := insertTestExercise(t, models, "Press")
+
+	var inserted []*Workout
+	for i := 0; i < 5; i++ {
+		inserted = append(inserted, testWorkout(1, exerciseId, testDay(1+i), 40+i))
+	}
+	if _, _, err := models.WorkoutModel.InsertBatch(ctx, inserted); err != nil {
+		t.Fatalf("InsertBatch: %v", err)
+	}
+
+	tests := []struct {
+		page     int
+		want     []int
+		metadata filters.Metadata
+	}{
+		{page: 1, want: []int{0, 1}, metadata: filters.Metadata{CurrentPage: 1, PageSize: 2, FirstPage: 1, LastPage: 3, TotalRecords: 5}},
+		{page: 3, want: []int{4}, metadata: filters.Metadata{CurrentPage: 3, PageSize: 2, FirstPage: 1, LastPage: 3, TotalRecords: 5}},
+		// past the last page the SQL models count no rows at all
+		{page: 4, want: []int{}, metadata: filters.Metadata{}},
+	}
+
+	for _, tt := range tests {
+		f := filters.Filters{Page: tt.page, PageSize: 2, Sort: "created_at", SortSafelist: []string{"created_at"}}
+		page, metadata, err := models.WorkoutModel.GetAll(ctx, 1, exerciseId, f)
+		if err != nil {
+			t.Fatalf("GetAll page %d: %v", tt.page, err)
+		}
+
+		if len(page) != len(tt.want) {
+			t.Fatalf("page %d has %d workouts, want %d", tt.page, len(page), len(tt.want))
+		}
+		for i, index := range tt.want {
+			if page[i].WorkoutId != inserted[index].WorkoutId {
+				t.Errorf("page %d row %d is workout %d, want %d", tt.page, i, page[i].WorkoutId, inserted[index].WorkoutId)
+			}
+		}
+		if metadata != tt.metadata {
+			t.Errorf("page %d metadata = %+v, want %+v", tt.page, metadata, tt.metadata)
+		}
+	}
+}
+
+func testCursorPaging(t *testing.T, models Models) {
+	ctx := context.Background()
+	exerciseId := insertTestExercise(t, models, "Row")
+
+	// two workouts share each timestamp, so pages have to break ties on the id
+	var inserted []*Workout
+	for i := 0; i < 7; i++ {
+		workout := testWorkout(1, exerciseId, testDay(1+i/2), 50+i)
+		inserted = append(inserted, workout)
+	}
+	inserted = append(inserted, testWorkout(2, exerciseId, testDay(1), 200))
+	if _, _, err := models.WorkoutModel.InsertBatch(ctx, inserted); err != nil {
+		t.Fatalf("InsertBatch: %v", err)
+	}
+
+	for _, descending := range []bool{false, true} {
+		var seen []int
+		cursor := filters.Cursor{Descending: descending}
+		for pages := 0; ; pages++ {
+			if pages > 10 {
+				t.Fatalf("descending=%v: paging did not end, saw %v", descending, seen)
+			}
+			page, next, err := models.WorkoutModel.GetAllAfter(ctx, 1, exerciseId, filters.Filters{PageSize: 2}, cursor)
+			if err != nil {
+				t.Fatalf("GetAllAfter: %v", err)
+			}
+			if len(page) > 2 {
+				t.Fatalf("descending=%v: page of %d workouts, want at most 2", descending, len(page))
+			}
+			for _, workout := range page {
+				seen = append(seen, workout.WorkoutId)
+			}
+			if next == nil {
+				break
+			}
+			cursor = *next
+		}
+
+		want := make([]int, 0, 7)
+		for i := 0; i < 7; i++ {
+			want = append(want, inserted[i].WorkoutId)
+		}
+		if descending {
+			for i, j := 0, len(want)-1; i < j; i, j = i+1, j-1 {
+				want[i], want[j] = want[j], want[i]
+			}
+		}
+		if len(seen) != len(want) {
+			t.Fatalf("descending=%v: paged through %v, want %v", descending, seen, want)
+		}
+		for i := range want {
+			if seen[i] != want[i] {
+				t.Fatalf("descending=%v: paged through %v, want %v", descending, seen, want)
+			}
+		}
+	}
+}
+
+func testBatchInsert(t *testing.T, models Models) {
+	ctx := context.Background()
+	bench := insertTestExercise(t, models, "Bench press")
+	squat := insertTestExercise(t, models, "Squat")
+
+	workouts := []*Workout{
+		testWorkout(1, bench, testDay(1), 80),
+		testWorkout(1, squat, testDay(1), 120),
+		testWorkout(1, bench, testDay(2), 85),
+	}
+	changes, _, err := models.WorkoutModel.InsertBatch(ctx, workouts)
+	if err != nil {
+		t.Fatalf("InsertBatch: %v", err)
+	}
+	for _, workout := range workouts {
+		if workout.WorkoutId < 1 || workout.Version != 1 {
+			t.Errorf("batch workout %+v, want an id and version 1", *workout)
+		}
+	}
+
+	// one change per key, against the records from before the batch
+	assertPrChanges(t, changes, []PrChange{
+		{PrKey: PrKey{UserId: 1, ExerciseId: bench}, Previous: 0, Current: 85, AchievedAt: ptr(testDay(2))},
+		{PrKey: PrKey{UserId: 1, ExerciseId: squat}, Previous: 0, Current: 120, AchievedAt: ptr(testDay(1))},
+	})
+	assertPr(t, models, PrKey{UserId: 1, ExerciseId: bench}, 85, testDay(2))
+	assertPr(t, models, PrKey{UserId: 1, ExerciseId: squat}, 120, testDay(1))
+}
+
+func testBatchInsertFailure(t *testing.T, models Models) {
+	ctx := context.Background()
+	bench := insertTestExercise(t, models, "Bench press")
+
+	workouts := []*Workout{
+		testWorkout(1, bench, testDay(1), 80),
+		testWorkout(1, bench+100, testDay(1), 90),
+		testWorkout(1, bench, testDay(2), 85),
+		testWorkout(1, bench+101, testDay(2), 95),
+	}
+	_, _, err := models.WorkoutModel.InsertBatch(ctx, workouts)
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("InsertBatch = %v, want a *BatchError", err)
+	}
+	var indexes []int
+	for _, item := range batchErr.Items {
+		indexes = append(indexes, item.Index)
+	}
+	if len(indexes) != 2 || indexes[0] != 1 || indexes[1] != 3 {
+		t.Errorf("failed items %v, want [1 3]", indexes)
+	}
+
+	stored, err := models.WorkoutModel.GetByUserId(ctx, 1)
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("GetByUserId: %v", err)
+	}
+	if len(stored) != 0 {
+		t.Errorf("%d workouts stored after a failed batch, want none", len(stored))
+	}
+	if _, err = models.PrModel.Get(ctx, 1, bench); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("PR after a failed batch = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testAchievementAwards(t *testing.T, models Models) {
+	ctx := context.Background()
+	bench := insertTestExercise(t, models, "Bench press")
+
+	workouts := []*Workout{
+		testWorkout(1, bench, testDay(1), 120),
+		testWorkout(1, bench, testDay(8), 80),
+		testWorkout(1, bench, testDay(15), 80),
+	}
+	_, earned, err := models.WorkoutModel.InsertBatch(ctx, workouts)
+	if err != nil {
+		t.Fatalf("InsertBatch: %v", err)
+	}
+	assertEarned(t, earned, map[string]int{
+		"first_workout": workouts[0].WorkoutId,
+		"bench_100kg":   workouts[0].WorkoutId,
+	})
+
+	// the fourth week in a row completes the streak
+	workout := testWorkout(1, bench, testDay(22), 80)
+	_, earned, err = models.WorkoutModel.Insert(ctx, workout)
+	if err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	assertEarned(t, earned, map[string]int{"streak_4_weeks": workout.WorkoutId})
+
+	// badges are only awarded once
+	workout.Weights = []int{125}
+	_, earned, err = models.WorkoutModel.Update(ctx, workout)
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	assertEarned(t, earned, map[string]int{})
+
+	stored, err := models.AchievementModel.GetAll(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(stored) != 3 {
+		t.Errorf("GetAll = %+v, want 3 badges", stored)
+	}
+}
+
+func testE1rmLeaderboard(t *testing.T, models Models) {
+	ctx := context.Background()
+	bench := insertTestExercise(t, models, "Bench press")
+
+	for userId := 1; userId <= 3; userId++ {
+		profile := Profile{UserId: userId, Unit: "kg", LeaderboardOptIn: true}
+		if err := models.ProfileModel.Upsert(ctx, &profile); err != nil {
+			t.Fatalf("Upsert: %v", err)
+		}
+	}
+
+	heavy := testWorkout(1, bench, testDay(1), 100)
+	heavy.Reps = []int{1}
+	workouts := []*Workout{heavy, testWorkout(2, bench, testDay(2), 90), testWorkout(3, bench, testDay(3), 75)}
+	if _, _, err := models.WorkoutModel.InsertBatch(ctx, workouts); err != nil {
+		t.Fatalf("InsertBatch: %v", err)
+	}
+
+	// 90kg for 5 reps beats a single at 100kg, 75kg for 5 reps is only 87.5kg
+	filter := LeaderboardFilter{ExerciseId: bench, Metric: MetricE1rm, CallerId: 3, Limit: 1}
+	leaderboard, err := models.LeaderboardModel.Get(ctx, filter)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if leaderboard.TotalRanked != 3 || len(leaderboard.Entries) != 1 || leaderboard.Entries[0].UserId != 2 ||
+		!leaderboard.Entries[0].AchievedAt.Equal(testDay(2)) {
+		t.Errorf("leaderboard = %+v, want user 2 on top of 3", leaderboard)
+	}
+	if leaderboard.Caller == nil || leaderboard.Caller.Rank != 3 {
+		t.Errorf("caller = %+v, want rank 3", leaderboard.Caller)
+	}
+
+	// a tie at the last rank within the limit returns every tied user
+	workouts[1].Weights, workouts[1].Reps = []int{100}, []int{1}
+	if _, _, err = models.WorkoutModel.Update(ctx, workouts[1]); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	leaderboard, err = models.LeaderboardModel.Get(ctx, filter)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if len(leaderboard.Entries) != 2 || leaderboard.Entries[0].Rank != 1 || leaderboard.Entries[1].Rank != 1 {
+		t.Errorf("entries = %+v, want users 1 and 2 sharing rank 1", leaderboard.Entries)
+	}
+}
+
+func testProfilePlates(t *testing.T, models Models) {
+	ctx := context.Background()
+
+	profile := Profile{UserId: 1, Unit: "kg"}
+	if err := models.ProfileModel.Upsert(ctx, &profile); err != nil {
+		t.Fatalf("Upsert: %v", err)
+	}
+	if profile.Plates == nil {
+		t.Error("Upsert left plates nil, want []")
+	}
+
+	got, err := models.ProfileModel.Get(ctx, 1)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Plates == nil || len(got.Plates) != 0 {
+		t.Errorf("Get plates = %#v, want []", got.Plates)
+	}
+}
+
+func testDay(n int) time.Time {
+	return time.Date(2024, 3, n, 9, 0, 0, 0, time.UTC)
+}
+
+func testWorkout(userId, exerciseId int, createdAt time.Time, weights ...int) *Workout {
+	reps := make([]int, len(weights))
+	for i := range reps {
+		reps[i] = 5
+	}
+	return &Workout{
+		UserId:     userId,
+		ExerciseId: exerciseId,
+		Duration:   30,
+		Sets:       len(weights),
+		Reps:       reps,
+		Weights:    weights,
+		Rpe:        8,
+		CreatedAt:  createdAt,
+	}
+}
+
+func insertTestExercise(t *testing.T, models Models, name string) int {
+	t.Helper()
+
+	exercise := Exercise{ExerciseName: name, ExerciseDescription: name}
+	if err := models.ExerciseModel.Insert(context.Background(), &exercise); err != nil {
+		t.Fatalf("inserting exercise %q: %v", name, err)
+	}
+	return exercise.ExerciseID
+}
+
+func getTestWorkout(t *testing.T, models Models, workoutId int) *Workout {
+	t.Helper()
+
+	workouts, err := models.WorkoutModel.GetByWorkoutId(context.Background(), workoutId)
+	if err != nil {
+		t.Fatalf("GetByWorkoutId: %v", err)
+	}
+	if len(workouts) != 1 {
+		t.Fatalf("GetByWorkoutId returned %d workouts, want 1", len(workouts))
+	}
+	return workouts[0]
+}
+
+func assertEarned(t *testing.T, earned []EarnedAchievement, want map[string]int) {
+	t.Helper()
+
+	got := make(map[string]int, len(earned))
+	for _, achievement := range earned {
+		got[achievement.Code] = achievement.WorkoutId
+	}
+	if len(got) != len(want) {
+		t.Fatalf("earned %v, want %v", got, want)
+	}
+	for code, workoutId := range want {
+		if got[code] != workoutId {
+			t.Errorf("%s earned with workout %d, want %d", code, got[code], workoutId)
+		}
+	}
+}
+
+func assertPr(t *testing.T, models Models, key PrKey, want int, achievedAt time.Time) {
+	t.Helper()
+
+	pr, err := models.PrModel.Get(context.Background(), key.UserId, key.ExerciseId)
+	if err != nil {
+		t.Fatalf("PrModel.Get: %v", err)
+	}
+	if pr.PersonalRecord != want || !pr.AchievedAt.Equal(achievedAt) {
+		t.Errorf("PR of %+v = %d at %v, want %d at %v", key, pr.PersonalRecord, pr.AchievedAt, want, achievedAt)
+	}
+}
@@ -5,27 +5,33 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"time"
+	"workout-microservice/internal/filters"
+	"workout-microservice/internal/jsonlog"
 	"workout-microservice/internal/validator"
 )
 
-const insertExerciseQuery = `INSERT INTO exercises (exercise_name, exercise_description) VALUES ($1, $2) RETURNING exercise_id;`
-const deleteExerciseQuery = `DELETE FROM exercises WHERE exercise_id = $1;`
-const updateExerciseQuery = `UPDATE exercises SET (exercise_name, exercise_description, exercise_version) = ($1, $2, $3) 
-                 WHERE exercise_id = $4 AND exercise_version = $5;`
+const insertExerciseQuery = `INSERT INTO exercises (exercise_name, exercise_description) VALUES ($1, $2) RETURNING exercise_id, exercise_version;`
+const deleteExerciseQuery = `DELETE FROM exercises WHERE exercise_id = $1 AND exercise_version = $2;`
+const updateExerciseQuery = `UPDATE exercises SET (exercise_name, exercise_description, exercise_version) = ($1, $2, exercise_version + 1)
+                 WHERE exercise_id = $3 AND exercise_version = $4
+                 RETURNING exercise_version;`
 const selectOneExerciseQuery = `SELECT exercise_id, exercise_name, exercise_description, exercise_version FROM exercises 
                                                         WHERE exercise_id = $1;`
-const selectAllExercisesQuery = `SELECT exercise_id, exercise_name, exercise_description FROM exercises;`
+const selectAllExercisesQuery = `SELECT count(*) OVER(), exercise_id, exercise_name, exercise_description, exercise_version FROM exercises
+ORDER BY %s %s, exercise_id ASC
+LIMIT $1 OFFSET $2;`
 
 type ExerciseModel struct {
-	db *sql.DB
+	db       *sql.DB
+	timeouts Timeouts
+	logger   *jsonlog.Logger
 }
 
 type Exercise struct {
-	ExerciseID          int
-	ExerciseName        string
-	ExerciseDescription string
-	ExerciseVersion     int `json:"-"`
+	ExerciseID          int    `json:"exercise_id"`
+	ExerciseName        string `json:"exercise_name"`
+	ExerciseDescription string `json:"exercise_description"`
+	ExerciseVersion     int    `json:"version"`
 }
 
 func ValidateExercise(v *validator.Validator, exercise *Exercise) bool {
@@ -35,8 +41,8 @@ func ValidateExercise(v *validator.Validator, exercise *Exercise) bool {
 	return v.Valid()
 }
 
-func (e ExerciseModel) Insert(exercise *Exercise) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*4)
+func (e ExerciseModel) Insert(ctx context.Context, exercise *Exercise) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeouts.Write)
 
 	defer cancel()
 
@@ -44,22 +50,27 @@ func (e ExerciseModel) Insert(exercise *Exercise) error {
 
 	err := e.db.QueryRowContext(ctx,
 		insertExerciseQuery,
-		args...).Scan(&exercise.ExerciseID)
+		args...).Scan(&exercise.ExerciseID, &exercise.ExerciseVersion)
 	if err != nil {
-		fmt.Println(err)
+		e.logger.PrintDebug("inserting exercise failed", map[string]string{
+			"exercise_name": exercise.ExerciseName,
+			"error":         err.Error(),
+		})
 	}
 	return err
 }
 
-func (e ExerciseModel) Delete(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*4)
+// Delete removes the exercise if it is still at the given version, returning
+// ErrEditConflict when it was changed or removed in the meantime.
+func (e ExerciseModel) Delete(ctx context.Context, id, version int) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeouts.Write)
 	defer cancel()
 
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	args := []interface{}{id}
+	args := []interface{}{id, version}
 	result, err := e.db.ExecContext(ctx, deleteExerciseQuery, args...)
 	if err != nil {
 		return err
@@ -70,13 +81,15 @@ func (e ExerciseModel) Delete(id int) error {
 		return err
 	}
 	if rowsAffected == 0 {
-		return ErrRecordNotFound
+		return ErrEditConflict
 	}
 	return nil
 }
 
-func (e ExerciseModel) Update(exercise *Exercise) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+// Update saves the exercise if it is still at exercise.ExerciseVersion and
+// bumps the version, returning ErrEditConflict when someone else updated it first.
+func (e ExerciseModel) Update(ctx context.Context, exercise *Exercise) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeouts.Write)
 	defer cancel()
 
 	if exercise.ExerciseID < 1 {
@@ -86,10 +99,9 @@ func (e ExerciseModel) Update(exercise *Exercise) error {
 	args := []interface{}{
 		exercise.ExerciseName,
 		exercise.ExerciseDescription,
-		exercise.ExerciseVersion + 1,
 		exercise.ExerciseID,
 		exercise.ExerciseVersion}
-	res, err := e.db.ExecContext(ctx, updateExerciseQuery, args...)
+	err := e.db.QueryRowContext(ctx, updateExerciseQuery, args...).Scan(&exercise.ExerciseVersion)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -99,19 +111,11 @@ func (e ExerciseModel) Update(exercise *Exercise) error {
 		}
 	}
 
-	rowsAffected, err := res.RowsAffected()
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
-	}
-	if err != nil {
-		return err
-	}
-
 	return nil
 }
 
-func (e ExerciseModel) Select(id int) (*Exercise, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+func (e ExerciseModel) Select(ctx context.Context, id int) (*Exercise, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeouts.Read)
 	defer cancel()
 
 	if id < 1 {
@@ -126,31 +130,43 @@ func (e ExerciseModel) Select(id int) (*Exercise, error) {
 		&exercise.ExerciseDescription,
 		&exercise.ExerciseVersion)
 	if err != nil {
-		return nil, ErrRecordNotFound
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
 	}
 
 	return &exercise, nil
 }
 
-func (e ExerciseModel) SelectAll() ([]Exercise, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+func (e ExerciseModel) SelectAll(ctx context.Context, f filters.Filters) ([]Exercise, filters.Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeouts.Read)
 	defer cancel()
 
-	rows, err := e.db.QueryContext(ctx, selectAllExercisesQuery)
+	query := fmt.Sprintf(selectAllExercisesQuery, f.SortColumn(), f.SortDirection())
+	rows, err := e.db.QueryContext(ctx, query, f.Limit(), f.Offset())
 	if err != nil {
-		fmt.Println("Error while fetching data from exercises table")
-		return nil, err
+		e.logger.PrintDebug("selecting exercises failed", map[string]string{"error": err.Error()})
+		return nil, filters.Metadata{}, err
 	}
+	defer rows.Close()
 
-	var exercises []Exercise
+	totalRecords := 0
+	exercises := []Exercise{}
 
 	for rows.Next() {
 		var exercise Exercise
-		if err := rows.Scan(&exercise.ExerciseID, &exercise.ExerciseName, &exercise.ExerciseDescription); err != nil {
-			fmt.Println("Error while fetching rows")
-			return nil, err
+		if err := rows.Scan(&totalRecords, &exercise.ExerciseID, &exercise.ExerciseName, &exercise.ExerciseDescription, &exercise.ExerciseVersion); err != nil {
+			e.logger.PrintDebug("scanning exercise failed", map[string]string{"error": err.Error()})
+			return nil, filters.Metadata{}, err
 		}
 		exercises = append(exercises, exercise)
 	}
-	return exercises, nil
+	if err = rows.Err(); err != nil {
+		return nil, filters.Metadata{}, err
+	}
+
+	return exercises, filters.CalculateMetadata(totalRecords, f.Page, f.PageSize), nil
 }
@@ -0,0 +1,150 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// removes an expired reservation of the key so that it can be taken again.
+const deleteExpiredIdempotencyKeyQuery = `DELETE FROM idempotency_keys WHERE idempotency_key = $1 AND expires_at < NOW();`
+
+const reserveIdempotencyKeyQuery = `INSERT INTO idempotency_keys (idempotency_key, fingerprint, expires_at)
+VALUES ($1, $2, NOW() + $3::double precision * INTERVAL '1 second')
+ON CONFLICT (idempotency_key) DO NOTHING;`
+
+const selectIdempotencyKeyQuery = `SELECT fingerprint, status, headers, body FROM idempotency_keys
+WHERE idempotency_key = $1;`
+
+const completeIdempotencyKeyQuery = `UPDATE idempotency_keys SET (status, headers, body) = ($2, $3, $4)
+WHERE idempotency_key = $1;`
+
+const releaseIdempotencyKeyQuery = `DELETE FROM idempotency_keys WHERE idempotency_key = $1 AND status IS NULL;`
+
+const deleteExpiredIdempotencyKeysQuery = `DELETE FROM idempotency_keys WHERE expires_at < NOW();`
+
+var (
+	// ErrIdempotencyKeyMismatch is returned when a key is reused for a request
+	// with a different fingerprint than the one it was first used with.
+	ErrIdempotencyKeyMismatch = errors.New("idempotency key was already used for a different request")
+	// ErrIdempotencyKeyInProgress is returned while the first request with the
+	// key has not finished yet.
+	ErrIdempotencyKeyInProgress = errors.New("a request with this idempotency key is still in progress")
+)
+
+// StoredResponse is the response saved for an idempotency key and replayed to
+// retries of the same request.
+type StoredResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+type IdempotencyModel struct {
+	db       *sql.DB
+	timeouts Timeouts
+}
+
+// Reserve claims key for a request with the given fingerprint. It returns a nil
+// response when the caller now owns the key and must run the request, or the
+// stored response of the first request when this is a retry.
+func (m IdempotencyModel) Reserve(ctx context.Context, key string, fingerprint []byte, ttl time.Duration) (*StoredResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeouts.Write)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, deleteExpiredIdempotencyKeyQuery, key)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := m.db.ExecContext(ctx, reserveIdempotencyKeyQuery, key, fingerprint, int64(ttl.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 1 {
+		return nil, nil
+	}
+
+	return m.stored(ctx, key, fingerprint)
+}
+
+// stored returns the response saved for a key that is already reserved.
+func (m IdempotencyModel) stored(ctx context.Context, key string, fingerprint []byte) (*StoredResponse, error) {
+	var stored StoredResponse
+	var storedFingerprint []byte
+	var status sql.NullInt64
+	var header []byte
+
+	err := m.db.QueryRowContext(ctx, selectIdempotencyKeyQuery, key).Scan(
+		&storedFingerprint,
+		&status,
+		&header,
+		&stored.Body)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// released by a failed first attempt in the meantime
+			return nil, ErrIdempotencyKeyInProgress
+		}
+		return nil, err
+	}
+
+	switch {
+	case !bytes.Equal(storedFingerprint, fingerprint):
+		return nil, ErrIdempotencyKeyMismatch
+	case !status.Valid:
+		return nil, ErrIdempotencyKeyInProgress
+	}
+
+	stored.Status = int(status.Int64)
+	if len(header) > 0 {
+		err = json.Unmarshal(header, &stored.Header)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &stored, nil
+}
+
+// Complete stores the response of the request that reserved key.
+func (m IdempotencyModel) Complete(ctx context.Context, key string, response StoredResponse) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeouts.Write)
+	defer cancel()
+
+	header, err := json.Marshal(response.Header)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.db.ExecContext(ctx, completeIdempotencyKeyQuery, key, response.Status, string(header), response.Body)
+	return err
+}
+
+// Release gives up a reservation that has not been completed, so that the
+// client can retry a request that failed on the server.
+func (m IdempotencyModel) Release(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeouts.Write)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, releaseIdempotencyKeyQuery, key)
+	return err
+}
+
+// DeleteExpired removes every key past its TTL and returns how many were removed.
+func (m IdempotencyModel) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeouts.Batch)
+	defer cancel()
+
+	res, err := m.db.ExecContext(ctx, deleteExpiredIdempotencyKeysQuery)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
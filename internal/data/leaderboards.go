@@ -0,0 +1,230 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"workout-microservice/internal/validator"
+)
+
+const (
+	MetricPr       = "pr"
+	MetricE1rm     = "e1rm"
+	MetricRelative = "relative"
+)
+
+// the score queries all expose (user_id, score, achieved_at) and only reference
+// $1 (exercise id), $7 (from) and $8 (to) so they can be dropped into leaderboardQuery.
+const prScoreQuery = `SELECT user_id, pr::double precision AS score, achieved_at FROM exercise_prs
+WHERE exercise_id = $1
+AND ($7::timestamptz IS NULL OR achieved_at >= $7)
+AND ($8::timestamptz IS NULL OR achieved_at < $8)`
+
+const relativeScoreQuery = `SELECT exercise_prs.user_id, pr / user_profiles.bodyweight AS score, achieved_at FROM exercise_prs
+JOIN user_profiles ON exercise_prs.user_id = user_profiles.user_id
+WHERE exercise_id = $1 AND user_profiles.bodyweight > 0
+AND ($7::timestamptz IS NULL OR achieved_at >= $7)
+AND ($8::timestamptz IS NULL OR achieved_at < $8)`
+
+// e1rm ranks the best estimated one rep max prService keeps next to the PR,
+// see epley.
+const e1rmScoreQuery = `SELECT user_id, e1rm AS score, e1rm_achieved_at AS achieved_at FROM exercise_prs
+WHERE exercise_id = $1 AND e1rm > 0
+AND ($7::timestamptz IS NULL OR e1rm_achieved_at >= $7)
+AND ($8::timestamptz IS NULL OR e1rm_achieved_at < $8)`
+
+// rank <= $6 keeps every user tied at the last rank, see Leaderboard.
+const leaderboardQuery = `WITH scores AS (%s),
+eligible AS (
+    SELECT scores.user_id, score, achieved_at, user_profiles.bodyweight FROM scores
+    LEFT JOIN user_profiles ON scores.user_id = user_profiles.user_id
+    WHERE (COALESCE(user_profiles.leaderboard_opt_in, false) OR scores.user_id = $2)
+    AND ($3::text = '' OR user_profiles.sex = $3)
+    AND ($4::double precision = 0 OR user_profiles.bodyweight > $4)
+    AND ($5::double precision = 0 OR user_profiles.bodyweight <= $5)
+),
+ranked AS (
+    SELECT user_id, score, achieved_at, bodyweight,
+    RANK() OVER (ORDER BY score DESC) AS rank,
+    count(*) OVER() AS total
+    FROM eligible
+)
+SELECT user_id, score, achieved_at, bodyweight, rank, total FROM ranked
+WHERE rank <= $6 OR user_id = $2
+ORDER BY rank, user_id;`
+
+var leaderboardScoreQueries = map[string]string{
+	MetricPr:       prScoreQuery,
+	MetricE1rm:     e1rmScoreQuery,
+	MetricRelative: relativeScoreQuery,
+}
+
+// weight classes as upper bounds in kg, the last class of each list is open-ended ("120+").
+var weightClasses = map[string][]float64{
+	SexMale:   {59, 66, 74, 83, 93, 105, 120},
+	SexFemale: {47, 52, 57, 63, 69, 76, 84},
+}
+
+// epley estimates the one rep max of a set with the Epley formula, a single rep
+// is taken at face value.
+func epley(weight, reps int) float64 {
+	if reps <= 1 {
+		return float64(weight)
+	}
+	return float64(weight) * (1 + float64(reps)/30.0)
+}
+
+type LeaderboardFilter struct {
+	ExerciseId  int
+	Metric      string
+	CallerId    int
+	Sex         string
+	WeightClass string
+	From        time.Time
+	To          time.Time
+	Limit       int
+}
+
+type LeaderboardEntry struct {
+	Rank       int       `json:"rank"`
+	UserId     int       `json:"user_id"`
+	Score      float64   `json:"score"`
+	Bodyweight float64   `json:"bodyweight,omitempty"`
+	AchievedAt time.Time `json:"achieved_at"`
+}
+
+// Leaderboard holds the entries ranked within the limit of the filter. Tied
+// entries share a rank, so a tie at the last rank can leave more entries
+// than the limit.
+type Leaderboard struct {
+	ExerciseId  int                `json:"exercise_id"`
+	Metric      string             `json:"metric"`
+	TotalRanked int                `json:"total_ranked"`
+	Entries     []LeaderboardEntry `json:"entries"`
+	Caller      *LeaderboardEntry  `json:"caller,omitempty"`
+}
+
+type LeaderboardModel struct {
+	db       *sql.DB
+	timeouts Timeouts
+}
+
+func ValidateLeaderboardFilter(v *validator.Validator, f *LeaderboardFilter) bool {
+	v.Check(f.ExerciseId > 0, "exercise id", "should be > 0")
+	v.Check(validator.In(f.Metric, MetricPr, MetricE1rm, MetricRelative), "metric", "should be one of pr, e1rm or relative")
+	v.Check(f.CallerId >= 0, "user id", "should be >= 0")
+	v.Check(f.Limit > 0 && f.Limit <= 100, "limit", "should be between 1 and 100")
+	v.Check(f.Sex == "" || validator.In(f.Sex, SexMale, SexFemale), "sex", "should be male or female")
+	v.Check(f.From.IsZero() || f.To.IsZero() || f.From.Before(f.To), "from", "should be before to")
+	if f.WeightClass != "" {
+		v.Check(f.Sex != "", "weight class", "requires sex to be set")
+		if f.Sex != "" {
+			_, _, ok := weightClassBounds(f.Sex, f.WeightClass)
+			v.Check(ok, "weight class", fmt.Sprintf("is not a known %s weight class", f.Sex))
+		}
+	}
+	return v.Valid()
+}
+
+// weightClassBounds returns the exclusive lower and inclusive upper bodyweight of
+// the class, 0 meaning unbounded.
+func weightClassBounds(sex, class string) (float64, float64, bool) {
+	classes := weightClasses[sex]
+	if len(classes) == 0 {
+		return 0, 0, false
+	}
+
+	heaviest := classes[len(classes)-1]
+	if strings.HasSuffix(class, "+") {
+		limit, err := strconv.ParseFloat(strings.TrimSuffix(class, "+"), 64)
+		if err != nil || limit != heaviest {
+			return 0, 0, false
+		}
+		return heaviest, 0, true
+	}
+
+	limit, err := strconv.ParseFloat(class, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	for i := range classes {
+		if classes[i] == limit {
+			if i == 0 {
+				return 0, limit, true
+			}
+			return classes[i-1], limit, true
+		}
+	}
+	return 0, 0, false
+}
+
+func (l LeaderboardModel) Get(ctx context.Context, f LeaderboardFilter) (*Leaderboard, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.timeouts.Read)
+	defer cancel()
+
+	scoreQuery, ok := leaderboardScoreQueries[f.Metric]
+	if !ok {
+		return nil, fmt.Errorf("unknown leaderboard metric %q", f.Metric)
+	}
+
+	var lower, upper float64
+	if f.WeightClass != "" {
+		lower, upper, _ = weightClassBounds(f.Sex, f.WeightClass)
+	}
+
+	args := []interface{}{
+		f.ExerciseId,
+		f.CallerId,
+		f.Sex,
+		lower,
+		upper,
+		f.Limit,
+		sql.NullTime{Time: f.From, Valid: !f.From.IsZero()},
+		sql.NullTime{Time: f.To, Valid: !f.To.IsZero()},
+	}
+
+	rows, err := l.db.QueryContext(ctx, fmt.Sprintf(leaderboardQuery, scoreQuery), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	leaderboard := Leaderboard{
+		ExerciseId: f.ExerciseId,
+		Metric:     f.Metric,
+		Entries:    []LeaderboardEntry{},
+	}
+
+	for rows.Next() {
+		var entry LeaderboardEntry
+		var bodyweight sql.NullFloat64
+
+		err = rows.Scan(
+			&entry.UserId,
+			&entry.Score,
+			&entry.AchievedAt,
+			&bodyweight,
+			&entry.Rank,
+			&leaderboard.TotalRanked)
+		if err != nil {
+			return nil, err
+		}
+		entry.Bodyweight = bodyweight.Float64
+
+		if entry.UserId == f.CallerId {
+			caller := entry
+			leaderboard.Caller = &caller
+		}
+		if entry.Rank <= f.Limit {
+			leaderboard.Entries = append(leaderboard.Entries, entry)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &leaderboard, nil
+}
@@ -0,0 +1,36 @@
+package data
+
+import (
+	"context"
+	"time"
+	"workout-microservice/internal/training"
+)
+
+const selectDailyLoadQuery = `SELECT (created_at AT TIME ZONE 'UTC')::date AS day, count(*),
+COALESCE(SUM(rpe * duration), 0),
+COALESCE(SUM((SELECT SUM(s.weight * s.reps) FROM unnest(weights, reps) AS s(weight, reps))), 0)
+FROM workouts_table WHERE user_id = $1 AND created_at >= $2 AND created_at < $3
+GROUP BY day ORDER BY day;`
+
+// DailyLoads returns the per day training totals of the user in [from, to).
+func (w WorkoutModel) DailyLoads(ctx context.Context, userId int, from, to time.Time) ([]training.DailyLoad, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeouts.Read)
+	defer cancel()
+
+	rows, err := w.db.QueryContext(ctx, selectDailyLoadQuery, userId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var loads []training.DailyLoad
+	for rows.Next() {
+		var load training.DailyLoad
+		err = rows.Scan(&load.Date, &load.Sessions, &load.SessionRpeLoad, &load.VolumeLoad)
+		if err != nil {
+			return nil, err
+		}
+		loads = append(loads, load)
+	}
+	return loads, rows.Err()
+}
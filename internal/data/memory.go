@@ -0,0 +1,203 @@
+package data
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+	"workout-microservice/internal/achievements"
+	"workout-microservice/internal/filters"
+)
+
+// memoryStore holds every table of the in-memory backend behind one lock. It
+// follows the Postgres models closely, including the constraints the schema
+// enforces and the PR maintenance, so handlers behave the same on both.
+type memoryStore struct {
+	mu sync.RWMutex
+
+	exercises      map[int]Exercise
+	nextExerciseId int
+	workouts       map[int]Workout
+	nextWorkoutId  int
+	prs            map[PrKey]prRecord
+	profiles       map[int]Profile
+	achievements   map[int][]EarnedAchievement
+	idempotency    map[string]memIdempotencyKey
+	rules          []achievements.Rule
+}
+
+// NewMemoryModels returns models that keep everything in memory, for demos
+// and for exercising the handlers without a database. Nothing is persisted.
+// Workouts are written together with the badges of rules they earn.
+func NewMemoryModels(rules []achievements.Rule) Models {
+	s := &memoryStore{
+		exercises:    make(map[int]Exercise),
+		workouts:     make(map[int]Workout),
+		prs:          make(map[PrKey]prRecord),
+		profiles:     make(map[int]Profile),
+		achievements: make(map[int][]EarnedAchievement),
+		idempotency:  make(map[string]memIdempotencyKey),
+		rules:        rules,
+	}
+
+	return Models{
+		WorkoutModel:     memWorkoutModel{s: s},
+		ExerciseModel:    memExerciseModel{s: s},
+		PrModel:          memPrModel{s: s},
+		ProfileModel:     memProfileModel{s: s},
+		LeaderboardModel: memLeaderboardModel{s: s},
+		AchievementModel: memAchievementModel{s: s},
+		IdempotencyModel: memIdempotencyModel{s: s},
+	}
+}
+
+// memNow returns the current time at the precision of the timestamp(0) columns.
+func memNow() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// inRange mirrors the optional from/to conditions of the list queries.
+func inRange(t time.Time, f filters.Filters) bool {
+	return (f.From.IsZero() || !t.Before(f.From)) && (f.To.IsZero() || t.Before(f.To))
+}
+
+// sortRows orders rows by the filter's sort column like ORDER BY column
+// direction, id ASC does. compare returns the ordering of two rows on column.
+func sortRows[T any](rows []T, f filters.Filters, compare func(a, b T, column string) int, id func(T) int) {
+	column := f.SortColumn()
+	descending := f.SortDirection() == "DESC"
+
+	slices.SortStableFunc(rows, func(a, b T) int {
+		c := compare(a, b, column)
+		if descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+		return cmp.Compare(id(a), id(b))
+	})
+}
+
+// pageRows returns the rows of the filter's page, all of them when paging is
+// disabled, and the paging metadata. A page past the last one has empty
+// metadata, as the SQL models count the total with count(*) OVER() on the rows
+// of the page.
+func pageRows[T any](rows []T, f filters.Filters) ([]T, filters.Metadata) {
+	total := len(rows)
+	start := min(f.Offset(), total)
+	if start == total {
+		return rows[start:], filters.Metadata{}
+	}
+	end := total
+	if f.PageSize > 0 {
+		end = min(start+f.PageSize, total)
+	}
+	return rows[start:end], filters.CalculateMetadata(total, f.Page, f.PageSize)
+}
+
+type memProfileModel struct {
+	s *memoryStore
+}
+
+func (p memProfileModel) Get(ctx context.Context, userId int) (*Profile, error) {
+	p.s.mu.RLock()
+	defer p.s.mu.RUnlock()
+
+	profile, ok := p.s.profiles[userId]
+	if userId < 1 || !ok {
+		return nil, ErrRecordNotFound
+	}
+	profile.Plates = slices.Clone(profile.Plates)
+	normalisePlates(&profile)
+	return &profile, nil
+}
+
+func (p memProfileModel) Upsert(ctx context.Context, profile *Profile) error {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	profile.UpdatedAt = memNow()
+	normalisePlates(profile)
+	stored := *profile
+	stored.Plates = slices.Clone(profile.Plates)
+	p.s.profiles[profile.UserId] = stored
+	return nil
+}
+
+type memIdempotencyKey struct {
+	fingerprint []byte
+	response    *StoredResponse
+	expiresAt   time.Time
+}
+
+type memIdempotencyModel struct {
+	s *memoryStore
+}
+
+func (m memIdempotencyModel) Reserve(ctx context.Context, key string, fingerprint []byte, ttl time.Duration) (*StoredResponse, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	entry, ok := m.s.idempotency[key]
+	if !ok || entry.expiresAt.Before(time.Now()) {
+		m.s.idempotency[key] = memIdempotencyKey{
+			fingerprint: bytes.Clone(fingerprint),
+			expiresAt:   time.Now().Add(ttl),
+		}
+		return nil, nil
+	}
+
+	switch {
+	case !bytes.Equal(entry.fingerprint, fingerprint):
+		return nil, ErrIdempotencyKeyMismatch
+	case entry.response == nil:
+		return nil, ErrIdempotencyKeyInProgress
+	}
+
+	stored := *entry.response
+	stored.Header = stored.Header.Clone()
+	stored.Body = bytes.Clone(stored.Body)
+	return &stored, nil
+}
+
+func (m memIdempotencyModel) Complete(ctx context.Context, key string, response StoredResponse) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	entry, ok := m.s.idempotency[key]
+	if !ok {
+		return nil
+	}
+	response.Header = response.Header.Clone()
+	response.Body = bytes.Clone(response.Body)
+	entry.response = &response
+	m.s.idempotency[key] = entry
+	return nil
+}
+
+func (m memIdempotencyModel) Release(ctx context.Context, key string) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if entry, ok := m.s.idempotency[key]; ok && entry.response == nil {
+		delete(m.s.idempotency, key)
+	}
+	return nil
+}
+
+func (m memIdempotencyModel) DeleteExpired(ctx context.Context) (int64, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	var deleted int64
+	for key, entry := range m.s.idempotency {
+		if entry.expiresAt.Before(time.Now()) {
+			delete(m.s.idempotency, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,438 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"workout-microservice/internal/achievements"
+	"workout-microservice/internal/filters"
+)
+
+// memPrStore is the prStore of the in-memory backend. The caller holds the
+// write lock, so lock has nothing left to do. On a dry run nothing is written.
+type memPrStore struct {
+	s      *memoryStore
+	dryRun bool
+}
+
+func (m memPrStore) lock(context.Context, PrKey) error {
+	return nil
+}
+
+func (m memPrStore) current(_ context.Context, key PrKey) (*prRecord, error) {
+	record, ok := m.s.prs[key]
+	if !ok {
+		return nil, nil
+	}
+	return &record, nil
+}
+
+func (m memPrStore) best(_ context.Context, key PrKey) (*prRecord, error) {
+	var best *prRecord
+	for _, workout := range m.s.workouts {
+		if workout.UserId != key.UserId || workout.ExerciseId != key.ExerciseId {
+			continue
+		}
+		for i, weight := range workout.Weights {
+			switch {
+			case best == nil:
+				best = &prRecord{Pr: weight, AchievedAt: workout.CreatedAt}
+			case weight > best.Pr:
+				best.Pr, best.AchievedAt = weight, workout.CreatedAt
+			case weight == best.Pr && workout.CreatedAt.Before(best.AchievedAt):
+				best.AchievedAt = workout.CreatedAt
+			}
+
+			reps := workout.Reps[i]
+			if reps <= 0 {
+				continue
+			}
+			e1rm := epley(weight, reps)
+			switch {
+			case e1rm > best.E1rm:
+				best.E1rm, best.E1rmAchievedAt = e1rm, workout.CreatedAt
+			case e1rm == best.E1rm && workout.CreatedAt.Before(best.E1rmAchievedAt):
+				best.E1rmAchievedAt = workout.CreatedAt
+			}
+		}
+	}
+	return best, nil
+}
+
+func (m memPrStore) save(_ context.Context, key PrKey, record prRecord) error {
+	if !m.dryRun {
+		m.s.prs[key] = record
+	}
+	return nil
+}
+
+func (m memPrStore) remove(_ context.Context, key PrKey) error {
+	if !m.dryRun {
+		delete(m.s.prs, key)
+	}
+	return nil
+}
+
+type memPrModel struct {
+	s *memoryStore
+}
+
+func (p memPrModel) Insert(ctx context.Context, pr Pr) error {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	if _, ok := p.s.exercises[pr.ExerciseId]; !ok {
+		return fmt.Errorf("%w: exercise %d does not exist", ErrConstraintViolation, pr.ExerciseId)
+	}
+	p.s.prs[PrKey{UserId: pr.UserId, ExerciseId: pr.ExerciseId}] = prRecord{Pr: pr.PersonalRecord, AchievedAt: memNow()}
+	return nil
+}
+
+func (p memPrModel) Update(ctx context.Context, pr Pr) error {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	key := PrKey{UserId: pr.UserId, ExerciseId: pr.ExerciseId}
+	if _, ok := p.s.prs[key]; !ok {
+		return ErrRecordNotFound
+	}
+	p.s.prs[key] = prRecord{Pr: pr.PersonalRecord, AchievedAt: memNow()}
+	return nil
+}
+
+func (p memPrModel) Delete(ctx context.Context, pr Pr) error {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	key := PrKey{UserId: pr.UserId, ExerciseId: pr.ExerciseId}
+	if _, ok := p.s.prs[key]; !ok {
+		return ErrRecordNotFound
+	}
+	delete(p.s.prs, key)
+	return nil
+}
+
+// consolidate joins a stored PR with its exercise like the PR queries do.
+func (s *memoryStore) consolidate(key PrKey, record prRecord) (ConsolidatedPr, bool) {
+	exercise, ok := s.exercises[key.ExerciseId]
+	return ConsolidatedPr{
+		UserId:              key.UserId,
+		ExerciseId:          key.ExerciseId,
+		ExerciseName:        exercise.ExerciseName,
+		ExerciseDescription: exercise.ExerciseDescription,
+		PersonalRecord:      record.Pr,
+		AchievedAt:          record.AchievedAt,
+	}, ok
+}
+
+func (p memPrModel) Get(ctx context.Context, userId int, exerciseId int) (*ConsolidatedPr, error) {
+	p.s.mu.RLock()
+	defer p.s.mu.RUnlock()
+
+	record, ok := p.s.prs[PrKey{UserId: userId, ExerciseId: exerciseId}]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	pr, ok := p.s.consolidate(PrKey{UserId: userId, ExerciseId: exerciseId}, record)
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &pr, nil
+}
+
+func (p memPrModel) GetAll(ctx context.Context, userId int, f filters.Filters) ([]ConsolidatedPr, filters.Metadata, error) {
+	p.s.mu.RLock()
+	defer p.s.mu.RUnlock()
+
+	if len(f.SortSafelist) == 0 {
+		f.SortSafelist = []string{"exercise_id"}
+	}
+
+	prs := []ConsolidatedPr{}
+	for key, record := range p.s.prs {
+		if key.UserId != userId || !inRange(record.AchievedAt, f) {
+			continue
+		}
+		if pr, ok := p.s.consolidate(key, record); ok {
+			prs = append(prs, pr)
+		}
+	}
+
+	sortRows(prs, f, func(a, b ConsolidatedPr, column string) int {
+		switch column {
+		case "exercise_name":
+			return strings.Compare(a.ExerciseName, b.ExerciseName)
+		case "pr":
+			return cmp.Compare(a.PersonalRecord, b.PersonalRecord)
+		case "achieved_at":
+			return a.AchievedAt.Compare(b.AchievedAt)
+		}
+		return cmp.Compare(a.ExerciseId, b.ExerciseId)
+	}, func(pr ConsolidatedPr) int { return pr.ExerciseId })
+
+	page, metadata := pageRows(prs, f)
+	return append([]ConsolidatedPr{}, page...), metadata, nil
+}
+
+func (p memPrModel) Rebuild(ctx context.Context, opts RebuildOptions, progress func(RebuildProgress)) (*RebuildReport, error) {
+	return runRebuild(ctx, opts, progress, p.rebuildKeys, p.rebuildBatch)
+}
+
+func (p memPrModel) rebuildKeys(_ context.Context, opts RebuildOptions) ([]PrKey, error) {
+	p.s.mu.RLock()
+	defer p.s.mu.RUnlock()
+
+	matches := func(key PrKey) bool {
+		return (opts.UserId == 0 || key.UserId == opts.UserId) && (opts.ExerciseId == 0 || key.ExerciseId == opts.ExerciseId)
+	}
+
+	var keys []PrKey
+	for _, workout := range p.s.workouts {
+		if key := (PrKey{UserId: workout.UserId, ExerciseId: workout.ExerciseId}); matches(key) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range p.s.prs {
+		if matches(key) {
+			keys = append(keys, key)
+		}
+	}
+	return uniquePrKeys(keys), nil
+}
+
+func (p memPrModel) rebuildBatch(ctx context.Context, keys []PrKey, dryRun bool) ([]PrChange, error) {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	return prService{store: memPrStore{s: p.s, dryRun: dryRun}}.Recompute(ctx, keys...)
+}
+
+type memLeaderboardModel struct {
+	s *memoryStore
+}
+
+// scores returns the best score and when it was set for every user, following
+// the score queries of the Postgres leaderboard.
+func (l memLeaderboardModel) scores(f LeaderboardFilter) map[int]LeaderboardEntry {
+	window := filters.Filters{From: f.From, To: f.To}
+	scores := make(map[int]LeaderboardEntry)
+
+	switch f.Metric {
+	case MetricPr, MetricRelative:
+		for key, record := range l.s.prs {
+			if key.ExerciseId != f.ExerciseId || !inRange(record.AchievedAt, window) {
+				continue
+			}
+			score := float64(record.Pr)
+			if f.Metric == MetricRelative {
+				profile, ok := l.s.profiles[key.UserId]
+				if !ok || profile.Bodyweight <= 0 {
+					continue
+				}
+				score /= profile.Bodyweight
+			}
+			scores[key.UserId] = LeaderboardEntry{UserId: key.UserId, Score: score, AchievedAt: record.AchievedAt}
+		}
+	case MetricE1rm:
+		for key, record := range l.s.prs {
+			if key.ExerciseId != f.ExerciseId || record.E1rm <= 0 || !inRange(record.E1rmAchievedAt, window) {
+				continue
+			}
+			scores[key.UserId] = LeaderboardEntry{UserId: key.UserId, Score: record.E1rm, AchievedAt: record.E1rmAchievedAt}
+		}
+	}
+	return scores
+}
+
+func (l memLeaderboardModel) Get(ctx context.Context, f LeaderboardFilter) (*Leaderboard, error) {
+	l.s.mu.RLock()
+	defer l.s.mu.RUnlock()
+
+	if _, ok := leaderboardScoreQueries[f.Metric]; !ok {
+		return nil, fmt.Errorf("unknown leaderboard metric %q", f.Metric)
+	}
+
+	var lower, upper float64
+	if f.WeightClass != "" {
+		lower, upper, _ = weightClassBounds(f.Sex, f.WeightClass)
+	}
+
+	var eligible []LeaderboardEntry
+	for userId, entry := range l.scores(f) {
+		profile, hasProfile := l.s.profiles[userId]
+		switch {
+		case !profile.LeaderboardOptIn && userId != f.CallerId:
+			continue
+		case f.Sex != "" && (!hasProfile || profile.Sex != f.Sex):
+			continue
+		case lower != 0 && !(profile.Bodyweight > 0 && profile.Bodyweight > lower):
+			continue
+		case upper != 0 && !(profile.Bodyweight > 0 && profile.Bodyweight <= upper):
+			continue
+		}
+		entry.Bodyweight = profile.Bodyweight
+		eligible = append(eligible, entry)
+	}
+
+	slices.SortFunc(eligible, func(a, b LeaderboardEntry) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.UserId, b.UserId)
+	})
+
+	leaderboard := Leaderboard{
+		ExerciseId:  f.ExerciseId,
+		Metric:      f.Metric,
+		TotalRanked: len(eligible),
+		Entries:     []LeaderboardEntry{},
+	}
+
+	for i, entry := range eligible {
+		// RANK(): tied scores share the rank of the first of them
+		entry.Rank = i + 1
+		if i > 0 && entry.Score == eligible[i-1].Score {
+			entry.Rank = eligible[i-1].Rank
+		}
+		eligible[i] = entry
+
+		if entry.UserId == f.CallerId {
+			caller := entry
+			leaderboard.Caller = &caller
+		}
+		if entry.Rank <= f.Limit {
+			leaderboard.Entries = append(leaderboard.Entries, entry)
+		}
+	}
+
+	return &leaderboard, nil
+}
+
+type memAchievementModel struct {
+	s *memoryStore
+}
+
+func (a memAchievementModel) Stats(ctx context.Context, userId int) (achievements.Stats, error) {
+	a.s.mu.RLock()
+	defer a.s.mu.RUnlock()
+
+	return memAchievementStore{s: a.s}.stats(ctx, userId)
+}
+
+func (a memAchievementModel) GetAll(ctx context.Context, userId int) ([]EarnedAchievement, error) {
+	a.s.mu.RLock()
+	defer a.s.mu.RUnlock()
+
+	earned := append([]EarnedAchievement{}, a.s.achievements[userId]...)
+	slices.SortFunc(earned, func(x, y EarnedAchievement) int {
+		if c := x.EarnedAt.Compare(y.EarnedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(x.Code, y.Code)
+	})
+	return earned, nil
+}
+
+// memAchievementStore is the achievementStore of the in-memory backend. The
+// caller holds the lock.
+type memAchievementStore struct {
+	s *memoryStore
+}
+
+func (s *memoryStore) awardAchievements(ctx context.Context, userIds ...int) ([]EarnedAchievement, error) {
+	return achievementService{store: memAchievementStore{s: s}, rules: s.rules}.Award(ctx, userIds...)
+}
+
+// userWorkouts returns the workouts of the user in the order they were done.
+func (a memAchievementStore) userWorkouts(userId int) []*Workout {
+	return a.s.selectWorkouts(func(workout Workout) bool {
+		return workout.UserId == userId
+	})
+}
+
+func (a memAchievementStore) stats(ctx context.Context, userId int) (achievements.Stats, error) {
+	stats := achievements.Stats{MaxWeights: make(map[string]int)}
+	for _, workout := range a.userWorkouts(userId) {
+		stats.WorkoutCount++
+		stats.TotalVolume += workoutVolume(workout)
+
+		week := achievements.WeekStart(workout.CreatedAt)
+		if len(stats.Weeks) == 0 || !stats.Weeks[len(stats.Weeks)-1].Equal(week) {
+			stats.Weeks = append(stats.Weeks, week)
+		}
+	}
+	for key, record := range a.s.prs {
+		exercise, ok := a.s.exercises[key.ExerciseId]
+		if key.UserId != userId || !ok {
+			continue
+		}
+		name := strings.ToLower(exercise.ExerciseName)
+		stats.MaxWeights[name] = max(stats.MaxWeights[name], record.Pr)
+	}
+
+	stats.WeeklyStreak = achievements.LongestWeeklyStreak(stats.Weeks)
+	return stats, nil
+}
+
+func (a memAchievementStore) earned(ctx context.Context, userId int) (map[string]bool, error) {
+	codes := make(map[string]bool)
+	for _, achievement := range a.s.achievements[userId] {
+		codes[achievement.Code] = true
+	}
+	return codes, nil
+}
+
+// firstWorkout returns the first workout of the user that qualifies, 0 if none does.
+func (a memAchievementStore) firstWorkout(userId int, qualifies func(*Workout) bool) int {
+	for _, workout := range a.userWorkouts(userId) {
+		if qualifies(workout) {
+			return workout.WorkoutId
+		}
+	}
+	return 0
+}
+
+func (a memAchievementStore) nthWorkout(ctx context.Context, userId, n int) (int, error) {
+	count := 0
+	return a.firstWorkout(userId, func(*Workout) bool {
+		count++
+		return count >= n
+	}), nil
+}
+
+func (a memAchievementStore) volumeReached(ctx context.Context, userId, volume int) (int, error) {
+	total := 0
+	return a.firstWorkout(userId, func(workout *Workout) bool {
+		total += workoutVolume(workout)
+		return total >= volume
+	}), nil
+}
+
+func (a memAchievementStore) weightReached(ctx context.Context, userId int, exerciseName string, weight int) (int, error) {
+	return a.firstWorkout(userId, func(workout *Workout) bool {
+		exercise := a.s.exercises[workout.ExerciseId]
+		return strings.EqualFold(exercise.ExerciseName, exerciseName) && slices.Max(workout.Weights) >= weight
+	}), nil
+}
+
+func (a memAchievementStore) firstWorkoutSince(ctx context.Context, userId int, since time.Time) (int, error) {
+	return a.firstWorkout(userId, func(workout *Workout) bool {
+		return !workout.CreatedAt.Before(since)
+	}), nil
+}
+
+func (a memAchievementStore) award(ctx context.Context, userId int, code string, workoutId int) (*EarnedAchievement, error) {
+	for _, achievement := range a.s.achievements[userId] {
+		if achievement.Code == code {
+			return nil, nil
+		}
+	}
+
+	achievement := EarnedAchievement{UserId: userId, Code: code, WorkoutId: workoutId, EarnedAt: memNow()}
+	a.s.achievements[userId] = append(a.s.achievements[userId], achievement)
+	return &achievement, nil
+}
@@ -0,0 +1,378 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"workout-microservice/internal/filters"
+	"workout-microservice/internal/training"
+)
+
+type memWorkoutModel struct {
+	s *memoryStore
+}
+
+func cloneWorkout(workout Workout) *Workout {
+	workout.Reps = slices.Clone(workout.Reps)
+	workout.Weights = slices.Clone(workout.Weights)
+	return &workout
+}
+
+// checkWorkout enforces the foreign key and check constraints of workouts_table.
+func (s *memoryStore) checkWorkout(workout *Workout) error {
+	if _, ok := s.exercises[workout.ExerciseId]; !ok {
+		return fmt.Errorf("%w: exercise %d does not exist", ErrConstraintViolation, workout.ExerciseId)
+	}
+	if workout.Sets <= 0 || len(workout.Reps) != workout.Sets || len(workout.Weights) != workout.Sets {
+		return fmt.Errorf("%w: reps and weights must have one entry for each set", ErrConstraintViolation)
+	}
+	return nil
+}
+
+// insertWorkout stores a checked workout, filling in its id, version and
+// creation time. The caller holds the write lock.
+func (s *memoryStore) insertWorkout(workout *Workout) {
+	s.nextWorkoutId++
+	workout.WorkoutId = s.nextWorkoutId
+	workout.Version = 1
+	if workout.CreatedAt.IsZero() {
+		workout.CreatedAt = memNow()
+	} else {
+		workout.CreatedAt = workout.CreatedAt.UTC().Truncate(time.Second)
+	}
+	s.workouts[workout.WorkoutId] = *cloneWorkout(*workout)
+}
+
+func (s *memoryStore) recomputePrs(ctx context.Context, keys ...PrKey) ([]PrChange, error) {
+	return prService{store: memPrStore{s: s}}.Recompute(ctx, keys...)
+}
+
+func (w memWorkoutModel) Insert(ctx context.Context, workout *Workout) ([]PrChange, []EarnedAchievement, error) {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+
+	err := w.s.checkWorkout(workout)
+	if err != nil {
+		return nil, nil, err
+	}
+	w.s.insertWorkout(workout)
+
+	changes, err := w.s.recomputePrs(ctx, PrKey{UserId: workout.UserId, ExerciseId: workout.ExerciseId})
+	if err != nil {
+		return nil, nil, err
+	}
+	earned, err := w.s.awardAchievements(ctx, workout.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
+	return changes, earned, nil
+}
+
+func (w memWorkoutModel) InsertBatch(ctx context.Context, workouts []*Workout) ([]PrChange, []EarnedAchievement, error) {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+
+	// check everything first so that a rejected workout leaves nothing behind
+	var batchErr BatchError
+	for i, workout := range workouts {
+		if err := w.s.checkWorkout(workout); err != nil {
+			batchErr.add(i, err)
+		}
+	}
+	if err := batchErr.err(); err != nil {
+		return nil, nil, err
+	}
+
+	keys := make([]PrKey, 0, len(workouts))
+	userIds := make([]int, 0, len(workouts))
+	for _, workout := range workouts {
+		w.s.insertWorkout(workout)
+		keys = append(keys, PrKey{UserId: workout.UserId, ExerciseId: workout.ExerciseId})
+		userIds = append(userIds, workout.UserId)
+	}
+
+	changes, err := w.s.recomputePrs(ctx, keys...)
+	if err != nil {
+		return nil, nil, err
+	}
+	earned, err := w.s.awardAchievements(ctx, userIds...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return changes, earned, nil
+}
+
+func (w memWorkoutModel) Update(ctx context.Context, workout *Workout) ([]PrChange, []EarnedAchievement, error) {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+
+	existing, ok := w.s.workouts[workout.WorkoutId]
+	if !ok || existing.UserId != workout.UserId || existing.Version != workout.Version {
+		return nil, nil, ErrEditConflict
+	}
+	err := w.s.checkWorkout(workout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	workout.Version = existing.Version + 1
+	workout.CreatedAt = existing.CreatedAt
+	w.s.workouts[workout.WorkoutId] = *cloneWorkout(*workout)
+
+	changes, err := w.s.recomputePrs(ctx,
+		PrKey{UserId: existing.UserId, ExerciseId: existing.ExerciseId},
+		PrKey{UserId: workout.UserId, ExerciseId: workout.ExerciseId})
+	if err != nil {
+		return nil, nil, err
+	}
+	earned, err := w.s.awardAchievements(ctx, workout.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
+	return changes, earned, nil
+}
+
+func (w memWorkoutModel) Delete(ctx context.Context, workoutId, version int) ([]PrChange, error) {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+
+	existing, ok := w.s.workouts[workoutId]
+	if !ok || existing.Version != version {
+		return nil, ErrEditConflict
+	}
+	delete(w.s.workouts, workoutId)
+
+	return w.s.recomputePrs(ctx, PrKey{UserId: existing.UserId, ExerciseId: existing.ExerciseId})
+}
+
+func (w memWorkoutModel) GetByWorkoutId(ctx context.Context, workoutId int) ([]*Workout, error) {
+	w.s.mu.RLock()
+	defer w.s.mu.RUnlock()
+
+	workout, ok := w.s.workouts[workoutId]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	return []*Workout{cloneWorkout(workout)}, nil
+}
+
+// selectWorkouts returns copies of the workouts matching keep in
+// (created_at, workout_id) order. The caller holds the read lock.
+func (s *memoryStore) selectWorkouts(keep func(Workout) bool) []*Workout {
+	var workouts []*Workout
+	for _, workout := range s.workouts {
+		if keep(workout) {
+			workouts = append(workouts, cloneWorkout(workout))
+		}
+	}
+	slices.SortFunc(workouts, compareWorkoutsByTime)
+	return workouts
+}
+
+func compareWorkoutsByTime(a, b *Workout) int {
+	if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.WorkoutId, b.WorkoutId)
+}
+
+func (w memWorkoutModel) GetByUserIdAndExerciseId(ctx context.Context, userId, exerciseId int) ([]*Workout, error) {
+	w.s.mu.RLock()
+	defer w.s.mu.RUnlock()
+
+	return w.s.selectWorkouts(func(workout Workout) bool {
+		return workout.UserId == userId && workout.ExerciseId == exerciseId
+	}), nil
+}
+
+func (w memWorkoutModel) GetByUserId(ctx context.Context, userId int) ([]*Workout, error) {
+	w.s.mu.RLock()
+	defer w.s.mu.RUnlock()
+
+	return w.s.selectWorkouts(func(workout Workout) bool {
+		return workout.UserId == userId
+	}), nil
+}
+
+func (w memWorkoutModel) GetAll(ctx context.Context, userId, exerciseId int, f filters.Filters) ([]*Workout, filters.Metadata, error) {
+	w.s.mu.RLock()
+	defer w.s.mu.RUnlock()
+
+	workouts := w.s.selectWorkouts(func(workout Workout) bool {
+		return workout.UserId == userId && (exerciseId == 0 || workout.ExerciseId == exerciseId) &&
+			inRange(workout.CreatedAt, f)
+	})
+
+	sortRows(workouts, f, func(a, b *Workout, column string) int {
+		switch column {
+		case "created_at":
+			return a.CreatedAt.Compare(b.CreatedAt)
+		case "exercise_id":
+			return cmp.Compare(a.ExerciseId, b.ExerciseId)
+		case "duration":
+			return cmp.Compare(a.Duration, b.Duration)
+		case "sets":
+			return cmp.Compare(a.Sets, b.Sets)
+		}
+		return cmp.Compare(a.WorkoutId, b.WorkoutId)
+	}, func(workout *Workout) int { return workout.WorkoutId })
+
+	page, metadata := pageRows(workouts, f)
+	return append([]*Workout{}, page...), metadata, nil
+}
+
+func (w memWorkoutModel) GetAllAfter(ctx context.Context, userId, exerciseId int, f filters.Filters, after filters.Cursor) ([]*Workout, *filters.Cursor, error) {
+	w.s.mu.RLock()
+	defer w.s.mu.RUnlock()
+
+	position := &Workout{CreatedAt: after.CreatedAt, WorkoutId: after.Id}
+	workouts := w.s.selectWorkouts(func(workout Workout) bool {
+		if workout.UserId != userId || (exerciseId != 0 && workout.ExerciseId != exerciseId) || !inRange(workout.CreatedAt, f) {
+			return false
+		}
+		if after.IsZero() {
+			return true
+		}
+		c := compareWorkoutsByTime(&workout, position)
+		return (after.Descending && c < 0) || (!after.Descending && c > 0)
+	})
+	if after.Descending {
+		slices.Reverse(workouts)
+	}
+
+	if len(workouts) <= f.PageSize {
+		return append([]*Workout{}, workouts...), nil, nil
+	}
+
+	workouts = workouts[:f.PageSize]
+	last := workouts[len(workouts)-1]
+	next := filters.Cursor{CreatedAt: last.CreatedAt, Id: last.WorkoutId, Descending: after.Descending}
+	return workouts, &next, nil
+}
+
+func (w memWorkoutModel) DailyLoads(ctx context.Context, userId int, from, to time.Time) ([]training.DailyLoad, error) {
+	w.s.mu.RLock()
+	defer w.s.mu.RUnlock()
+
+	workouts := w.s.selectWorkouts(func(workout Workout) bool {
+		return workout.UserId == userId && !workout.CreatedAt.Before(from) && workout.CreatedAt.Before(to)
+	})
+
+	var loads []training.DailyLoad
+	for _, workout := range workouts {
+		created := workout.CreatedAt.UTC()
+		day := time.Date(created.Year(), created.Month(), created.Day(), 0, 0, 0, 0, time.UTC)
+		if len(loads) == 0 || !loads[len(loads)-1].Date.Equal(day) {
+			loads = append(loads, training.DailyLoad{Date: day})
+		}
+
+		load := &loads[len(loads)-1]
+		load.Sessions++
+		load.SessionRpeLoad += workout.Rpe * float64(workout.Duration)
+		load.VolumeLoad += float64(workoutVolume(workout))
+	}
+	return loads, nil
+}
+
+// workoutVolume is the sum of weight * reps over the sets of the workout.
+func workoutVolume(workout *Workout) int {
+	volume := 0
+	for i := range workout.Weights {
+		volume += workout.Weights[i] * workout.Reps[i]
+	}
+	return volume
+}
+
+type memExerciseModel struct {
+	s *memoryStore
+}
+
+func (e memExerciseModel) Insert(ctx context.Context, exercise *Exercise) error {
+	e.s.mu.Lock()
+	defer e.s.mu.Unlock()
+
+	e.s.nextExerciseId++
+	exercise.ExerciseID = e.s.nextExerciseId
+	exercise.ExerciseVersion = 1
+	e.s.exercises[exercise.ExerciseID] = *exercise
+	return nil
+}
+
+func (e memExerciseModel) Update(ctx context.Context, exercise *Exercise) error {
+	e.s.mu.Lock()
+	defer e.s.mu.Unlock()
+
+	existing, ok := e.s.exercises[exercise.ExerciseID]
+	if exercise.ExerciseID < 1 {
+		return ErrRecordNotFound
+	}
+	if !ok || existing.ExerciseVersion != exercise.ExerciseVersion {
+		return ErrEditConflict
+	}
+
+	exercise.ExerciseVersion++
+	e.s.exercises[exercise.ExerciseID] = *exercise
+	return nil
+}
+
+func (e memExerciseModel) Delete(ctx context.Context, id, version int) error {
+	e.s.mu.Lock()
+	defer e.s.mu.Unlock()
+
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	existing, ok := e.s.exercises[id]
+	if !ok || existing.ExerciseVersion != version {
+		return ErrEditConflict
+	}
+
+	// workouts and PRs reference exercises without ON DELETE CASCADE
+	for _, workout := range e.s.workouts {
+		if workout.ExerciseId == id {
+			return fmt.Errorf("%w: exercise %d is still referenced by workouts", ErrConstraintViolation, id)
+		}
+	}
+	for key := range e.s.prs {
+		if key.ExerciseId == id {
+			return fmt.Errorf("%w: exercise %d is still referenced by personal records", ErrConstraintViolation, id)
+		}
+	}
+
+	delete(e.s.exercises, id)
+	return nil
+}
+
+func (e memExerciseModel) Select(ctx context.Context, id int) (*Exercise, error) {
+	e.s.mu.RLock()
+	defer e.s.mu.RUnlock()
+
+	exercise, ok := e.s.exercises[id]
+	if id < 1 || !ok {
+		return nil, ErrRecordNotFound
+	}
+	return &exercise, nil
+}
+
+func (e memExerciseModel) SelectAll(ctx context.Context, f filters.Filters) ([]Exercise, filters.Metadata, error) {
+	e.s.mu.RLock()
+	defer e.s.mu.RUnlock()
+
+	exercises := make([]Exercise, 0, len(e.s.exercises))
+	for _, exercise := range e.s.exercises {
+		exercises = append(exercises, exercise)
+	}
+
+	sortRows(exercises, f, func(a, b Exercise, column string) int {
+		if column == "exercise_name" {
+			return strings.Compare(a.ExerciseName, b.ExerciseName)
+		}
+		return cmp.Compare(a.ExerciseID, b.ExerciseID)
+	}, func(exercise Exercise) int { return exercise.ExerciseID })
+
+	page, metadata := pageRows(exercises, f)
+	return append([]Exercise{}, page...), metadata, nil
+}
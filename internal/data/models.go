@@ -1,8 +1,16 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
+	"workout-microservice/internal/achievements"
+	"workout-microservice/internal/jsonlog"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
 var (
@@ -10,16 +18,58 @@ var (
 	ErrEditConflict   = errors.New("edit conflict occurred")
 )
 
+// Timeouts bound how long a single model operation may run, on top of any
+// deadline of the context it is called with. Read covers queries, Write single
+// inserts, updates and deletes including their PR recompute, and Batch batch
+// inserts and cleanups.
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+	Batch time.Duration
+}
+
+var DefaultTimeouts = Timeouts{
+	Read:  10 * time.Second,
+	Write: 10 * time.Second,
+	Batch: 30 * time.Second,
+}
+
+// IsCanceled reports whether err comes from a query that was stopped because
+// its context was cancelled or ran out of time. Postgres and SQLite report
+// such queries with errors of their own rather than the context's error.
+func IsCanceled(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "57014" {
+		return true
+	}
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqlite3.SQLITE_INTERRUPT
+}
+
 type Models struct {
-	WorkoutModel  WorkoutModel
-	ExerciseModel ExerciseModel
-	PrModel       PrModel
+	WorkoutModel     WorkoutRepository
+	ExerciseModel    ExerciseRepository
+	PrModel          PrRepository
+	ProfileModel     ProfileRepository
+	LeaderboardModel LeaderboardRepository
+	AchievementModel AchievementRepository
+	IdempotencyModel IdempotencyRepository
 }
 
-func NewModels(db *sql.DB) Models {
+// NewModels returns the Postgres backed models. Workouts are written together
+// with the badges of rules they earn.
+func NewModels(db *sql.DB, timeouts Timeouts, logger *jsonlog.Logger, rules []achievements.Rule) Models {
 	return Models{
-		WorkoutModel:  WorkoutModel{db: db},
-		ExerciseModel: ExerciseModel{db: db},
-		PrModel:       PrModel{db: db},
+		WorkoutModel:     WorkoutModel{db: db, timeouts: timeouts, logger: logger, rules: rules},
+		ExerciseModel:    ExerciseModel{db: db, timeouts: timeouts, logger: logger},
+		PrModel:          PrModel{db: db, timeouts: timeouts, logger: logger},
+		ProfileModel:     ProfileModel{db: db, timeouts: timeouts},
+		LeaderboardModel: LeaderboardModel{db: db, timeouts: timeouts},
+		AchievementModel: AchievementModel{db: db, timeouts: timeouts},
+		IdempotencyModel: IdempotencyModel{db: db, timeouts: timeouts},
 	}
 }
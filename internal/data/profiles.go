@@ -0,0 +1,151 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+	"workout-microservice/internal/plates"
+	"workout-microservice/internal/validator"
+)
+
+const selectProfileQuery = `SELECT user_id, bodyweight, sex, leaderboard_opt_in, unit, bar_weight, plates, updated_at
+FROM user_profiles WHERE user_id = $1;`
+
+const upsertProfileQuery = `INSERT INTO user_profiles (user_id, bodyweight, sex, leaderboard_opt_in, unit, bar_weight, plates, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+ON CONFLICT (user_id) DO UPDATE SET (bodyweight, sex, leaderboard_opt_in, unit, bar_weight, plates, updated_at) =
+(EXCLUDED.bodyweight, EXCLUDED.sex, EXCLUDED.leaderboard_opt_in, EXCLUDED.unit, EXCLUDED.bar_weight, EXCLUDED.plates, NOW())
+RETURNING updated_at;`
+
+const (
+	SexMale   = "male"
+	SexFemale = "female"
+)
+
+// Profile holds the per-user settings that are not part of a single workout,
+// such as bodyweight, whether the user wants to appear on leaderboards and the
+// bar and plates available to them. A zero BarWeight or empty Plates means the
+// defaults for Unit are used.
+type Profile struct {
+	UserId           int       `json:"user_id"`
+	Bodyweight       float64   `json:"bodyweight"`
+	Sex              string    `json:"sex"`
+	LeaderboardOptIn bool      `json:"leaderboard_opt_in"`
+	Unit             string    `json:"unit"`
+	BarWeight        float64   `json:"bar_weight"`
+	Plates           []float64 `json:"plates"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+type ProfileModel struct {
+	db       *sql.DB
+	timeouts Timeouts
+}
+
+func ValidateProfile(v *validator.Validator, profile *Profile) bool {
+	v.Check(profile.UserId > 0, "user id", "should be > 0")
+	v.Check(profile.Bodyweight >= 0, "bodyweight", "should be >= 0")
+	v.Check(profile.Bodyweight <= 500, "bodyweight", "should be <= 500")
+	v.Check(profile.Sex == "" || validator.In(profile.Sex, SexMale, SexFemale), "sex", "should be male or female")
+	v.Check(validator.In(profile.Unit, plates.UnitKg, plates.UnitLb), "unit", "should be kg or lb")
+	v.Check(profile.BarWeight >= 0, "bar weight", "should be >= 0")
+	v.Check(len(profile.Plates) <= 50, "plates", "should not list more than 50 pairs")
+	for _, plate := range profile.Plates {
+		v.Check(plates.ValidPlate(plate), "plates", fmt.Sprintf("every plate should weigh between 0.01 and %d", plates.MaxPlate))
+	}
+	return v.Valid()
+}
+
+func (p ProfileModel) Get(ctx context.Context, userId int) (*Profile, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Read)
+	defer cancel()
+
+	if userId < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	var profile Profile
+	var bodyweight sql.NullFloat64
+	var sex sql.NullString
+	var barWeight sql.NullFloat64
+
+	err := p.db.QueryRowContext(ctx, selectProfileQuery, userId).Scan(
+		&profile.UserId,
+		&bodyweight,
+		&sex,
+		&profile.LeaderboardOptIn,
+		&profile.Unit,
+		&barWeight,
+		pq.Array(&profile.Plates),
+		&profile.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	profile.Bodyweight = bodyweight.Float64
+	profile.Sex = sex.String
+	profile.BarWeight = barWeight.Float64
+	normalisePlates(&profile)
+
+	return &profile, nil
+}
+
+// normalisePlates makes an unset plate list serialise as [] rather than null,
+// whichever backend the profile came from.
+func normalisePlates(profile *Profile) {
+	if profile.Plates == nil {
+		profile.Plates = []float64{}
+	}
+}
+
+// Upsert creates the profile for the user or replaces the existing one.
+func (p ProfileModel) Upsert(ctx context.Context, profile *Profile) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+
+	args := []interface{}{
+		profile.UserId,
+		sql.NullFloat64{Float64: profile.Bodyweight, Valid: profile.Bodyweight > 0},
+		sql.NullString{String: profile.Sex, Valid: profile.Sex != ""},
+		profile.LeaderboardOptIn,
+		profile.Unit,
+		sql.NullFloat64{Float64: profile.BarWeight, Valid: profile.BarWeight > 0},
+		pq.Array(profile.Plates),
+	}
+
+	normalisePlates(profile)
+	return p.db.QueryRowContext(ctx, upsertProfileQuery, args...).Scan(&profile.UpdatedAt)
+}
+
+// Equipment returns the bar weight and plate inventory to use for unit, taking
+// the ones configured on the profile when they are in the same unit and the
+// standard gym defaults otherwise. profile may be nil.
+func Equipment(profile *Profile, unit string) (float64, []float64, error) {
+	bar, err := plates.DefaultBar(unit)
+	if err != nil {
+		return 0, nil, err
+	}
+	inventory, err := plates.DefaultInventory(unit)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if profile == nil || profile.Unit != unit {
+		return bar, inventory, nil
+	}
+	if profile.BarWeight > 0 {
+		bar = profile.BarWeight
+	}
+	if len(profile.Plates) > 0 {
+		inventory = profile.Plates
+	}
+	return bar, inventory, nil
+}
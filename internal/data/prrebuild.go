@@ -0,0 +1,132 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// every (user, exercise) pair that has workouts or a stored PR, so that PRs
+// left behind without workouts are found too. 0 matches every user/exercise.
+const selectPrKeysQuery = `SELECT user_id, exercise_id FROM workouts_table
+WHERE ($1::int = 0 OR user_id = $1) AND ($2::bigint = 0 OR exercise_id = $2)
+UNION
+SELECT user_id, exercise_id FROM exercise_prs
+WHERE ($1::int = 0 OR user_id = $1) AND ($2::bigint = 0 OR exercise_id = $2)
+ORDER BY user_id, exercise_id;`
+
+const DefaultRebuildBatchSize = 500
+
+// RebuildOptions selects which PRs a rebuild recomputes. A zero UserId or
+// ExerciseId matches all of them.
+type RebuildOptions struct {
+	UserId     int  `json:"user_id"`
+	ExerciseId int  `json:"exercise_id"`
+	DryRun     bool `json:"dry_run"`
+	BatchSize  int  `json:"batch_size"`
+}
+
+// RebuildProgress is reported after every batch of a rebuild.
+type RebuildProgress struct {
+	Done      int `json:"done"`
+	Total     int `json:"total"`
+	Corrected int `json:"corrected"`
+}
+
+// RebuildReport lists the PRs a rebuild corrected, or would correct on a dry run.
+type RebuildReport struct {
+	Options   RebuildOptions `json:"options"`
+	Checked   int            `json:"checked"`
+	Corrected []PrChange     `json:"corrected"`
+	StartedAt time.Time      `json:"started_at"`
+	Duration  string         `json:"duration"`
+}
+
+// Rebuild recomputes the PRs selected by opts from the workout history. Each
+// batch runs in its own transaction, on a dry run the transaction is rolled
+// back so nothing is written. progress may be nil.
+func (p PrModel) Rebuild(ctx context.Context, opts RebuildOptions, progress func(RebuildProgress)) (*RebuildReport, error) {
+	return runRebuild(ctx, opts, progress, p.rebuildKeys, p.rebuildBatch)
+}
+
+// runRebuild lists the keys selected by opts and recomputes them batch by
+// batch, which is the same for every backend. batch must not write anything
+// when dryRun is set.
+func runRebuild(
+	ctx context.Context,
+	opts RebuildOptions,
+	progress func(RebuildProgress),
+	listKeys func(ctx context.Context, opts RebuildOptions) ([]PrKey, error),
+	batch func(ctx context.Context, keys []PrKey, dryRun bool) ([]PrChange, error),
+) (*RebuildReport, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultRebuildBatchSize
+	}
+
+	report := &RebuildReport{
+		Options:   opts,
+		Corrected: []PrChange{},
+		StartedAt: time.Now(),
+	}
+
+	keys, err := listKeys(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for start := 0; start < len(keys); start += opts.BatchSize {
+		end := start + opts.BatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		changes, err := batch(ctx, keys[start:end], opts.DryRun)
+		if err != nil {
+			// the batches before this one are committed, so the report of
+			// what they corrected goes back with the error
+			report.Duration = time.Since(report.StartedAt).Round(time.Millisecond).String()
+			return report, err
+		}
+		report.Checked = end
+		report.Corrected = append(report.Corrected, changes...)
+
+		if progress != nil {
+			progress(RebuildProgress{Done: end, Total: len(keys), Corrected: len(report.Corrected)})
+		}
+	}
+
+	report.Duration = time.Since(report.StartedAt).Round(time.Millisecond).String()
+	return report, nil
+}
+
+func (p PrModel) rebuildKeys(ctx context.Context, opts RebuildOptions) ([]PrKey, error) {
+	rows, err := p.db.QueryContext(ctx, selectPrKeysQuery, opts.UserId, opts.ExerciseId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []PrKey
+	for rows.Next() {
+		var key PrKey
+		err = rows.Scan(&key.UserId, &key.ExerciseId)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (p PrModel) rebuildBatch(ctx context.Context, keys []PrKey, dryRun bool) ([]PrChange, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	changes, err := newPrService(tx).Recompute(ctx, keys...)
+	if err != nil || dryRun {
+		return changes, err
+	}
+	return changes, tx.Commit()
+}
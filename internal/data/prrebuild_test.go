@@ -0,0 +1,33 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRunRebuildKeepsReportOfCommittedBatches(t *testing.T) {
+	keys := []PrKey{{UserId: 1, ExerciseId: 1}, {UserId: 1, ExerciseId: 2}, {UserId: 2, ExerciseId: 1}}
+	failure := errors.New("connection reset")
+
+	listKeys := func(ctx context.Context, opts RebuildOptions) ([]PrKey, error) {
+		return keys, nil
+	}
+	batch := func(ctx context.Context, batch []PrKey, dryRun bool) ([]PrChange, error) {
+		if batch[0] == keys[2] {
+			return nil, failure
+		}
+		return []PrChange{{PrKey: batch[0], Previous: 100, Current: 90}}, nil
+	}
+
+	report, err := runRebuild(context.Background(), RebuildOptions{BatchSize: 1}, nil, listKeys, batch)
+	if !errors.Is(err, failure) {
+		t.Fatalf("runRebuild error = %v, want %v", err, failure)
+	}
+	if report == nil {
+		t.Fatal("runRebuild returned no report with the error")
+	}
+	if report.Checked != 2 || len(report.Corrected) != 2 || report.Duration == "" {
+		t.Errorf("report = %+v, want the 2 committed batches with a duration", *report)
+	}
+}
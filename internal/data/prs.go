@@ -5,20 +5,28 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
+	"workout-microservice/internal/filters"
+	"workout-microservice/internal/jsonlog"
 	"workout-microservice/internal/validator"
 )
 
 const insertPrQuery = `INSERT INTO exercise_prs(USER_ID, EXERCISE_ID, PR) VALUES ($1, $2, $3)`
 
-const selectPrQueryByBoth = `SELECT user_id, exercise_prs.exercise_id, exercise_name, exercise_description, pr FROM exercise_prs JOIN exercises
+const selectPrQueryByBoth = `SELECT user_id, exercise_prs.exercise_id, exercise_name, exercise_description, pr, achieved_at FROM exercise_prs JOIN exercises
 ON exercise_prs.exercise_id = exercises.exercise_id 
 WHERE (user_id, exercise_prs.exercise_id) = ($1, $2);`
 
-const selectPrByUserId = `SELECT user_id, exercise_prs.exercise_id, exercise_name, exercise_description, pr FROM exercise_prs JOIN exercises
-ON exercise_prs.exercise_id = exercises.exercise_id WHERE user_id = $1;`
+const selectPrByUserId = `SELECT count(*) OVER(), user_id, exercise_prs.exercise_id, exercise_name, exercise_description, pr, achieved_at
+FROM exercise_prs JOIN exercises
+ON exercise_prs.exercise_id = exercises.exercise_id WHERE user_id = $1
+AND ($2::timestamptz IS NULL OR achieved_at >= $2)
+AND ($3::timestamptz IS NULL OR achieved_at < $3)
+ORDER BY %s %s, exercise_id ASC
+LIMIT $4 OFFSET $5;`
 
-const updatePrQuery = `UPDATE exercise_prs SET pr = $1 WHERE (user_id, exercise_id) = ($2, $3)`
+const updatePrQuery = `UPDATE exercise_prs SET (pr, achieved_at) = ($1, NOW()) WHERE (user_id, exercise_id) = ($2, $3)`
 
 const deletePrQuery = `DELETE FROM exercise_prs WHERE (user_id, exercise_id) = ($1, $2)`
 
@@ -29,27 +37,32 @@ type Pr struct {
 }
 
 type ConsolidatedPr struct {
-	UserId              int    `json:"user_id"`
-	ExerciseId          int    `json:"exercise_id"`
-	ExerciseName        string `json:"exercise_name"`
-	ExerciseDescription string `json:"exercise_description"`
-	PersonalRecord      int    `json:"personal_record"`
+	UserId              int       `json:"user_id"`
+	ExerciseId          int       `json:"exercise_id"`
+	ExerciseName        string    `json:"exercise_name"`
+	ExerciseDescription string    `json:"exercise_description"`
+	PersonalRecord      int       `json:"personal_record"`
+	AchievedAt          time.Time `json:"achieved_at"`
 }
 
 type PrModel struct {
-	db *sql.DB
+	db       *sql.DB
+	timeouts Timeouts
+	logger   *jsonlog.Logger
 }
 
-func (p PrModel) Insert(pr Pr) error {
-	err := checkPr(p.db, pr.UserId, pr.ExerciseId)
+func (p PrModel) Insert(ctx context.Context, pr Pr) error {
+	err := checkPr(ctx, p.db, p.timeouts.Read, pr.UserId, pr.ExerciseId)
 	if err != nil {
 		// if there aren't any rows then we insert the row
 		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, ErrRecordNotFound) {
-			err2 := p.runQuery(insertPrQuery, []interface{}{pr.UserId, pr.ExerciseId, pr.PersonalRecord})
+			err2 := p.runQuery(ctx, insertPrQuery, []interface{}{pr.UserId, pr.ExerciseId, pr.PersonalRecord})
 			if err2 != nil {
-				fmt.Printf("error while inserting row with user id: %d and exercise id: %d \n",
-					pr.UserId,
-					pr.ExerciseId)
+				p.logger.PrintDebug("inserting personal record failed", map[string]string{
+					"user_id":     strconv.Itoa(pr.UserId),
+					"exercise_id": strconv.Itoa(pr.ExerciseId),
+					"error":       err2.Error(),
+				})
 				return err2
 			}
 
@@ -58,7 +71,7 @@ func (p PrModel) Insert(pr Pr) error {
 		return err
 	}
 
-	err = p.Update(pr)
+	err = p.Update(ctx, pr)
 	if err != nil {
 		return err
 	}
@@ -66,8 +79,8 @@ func (p PrModel) Insert(pr Pr) error {
 	return nil
 }
 
-func checkPr(db *sql.DB, userId int, exerciseId int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*4)
+func checkPr(ctx context.Context, db *sql.DB, timeout time.Duration, userId int, exerciseId int) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	args := []interface{}{userId, exerciseId}
 	var pr int
@@ -81,26 +94,29 @@ func checkPr(db *sql.DB, userId int, exerciseId int) error {
 	return nil
 }
 
-func (p PrModel) Update(pr Pr) error {
-	return p.runQuery(updatePrQuery, []interface{}{pr.PersonalRecord, pr.UserId, pr.ExerciseId})
+func (p PrModel) Update(ctx context.Context, pr Pr) error {
+	return p.runQuery(ctx, updatePrQuery, []interface{}{pr.PersonalRecord, pr.UserId, pr.ExerciseId})
 }
 
-func (p PrModel) Delete(pr Pr) error {
-	return p.runQuery(deletePrQuery, []interface{}{pr.UserId, pr.ExerciseId})
+func (p PrModel) Delete(ctx context.Context, pr Pr) error {
+	return p.runQuery(ctx, deletePrQuery, []interface{}{pr.UserId, pr.ExerciseId})
 }
 
-func (p PrModel) runQuery(query string, args []interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+func (p PrModel) runQuery(ctx context.Context, query string, args []interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Write)
 	defer cancel()
 	res, err := p.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
 	rowsAffected, err := res.RowsAffected()
 	if err != nil {
-		fmt.Println("Error while getting the value of rows affected")
+		p.logger.PrintDebug("reading rows affected failed", map[string]string{"error": err.Error()})
 		return err
 	}
 
 	if rowsAffected == 0 {
-		fmt.Println("No rows were affected! Please check logs!")
+		p.logger.PrintDebug("personal record query affected no rows", nil)
 		return ErrRecordNotFound
 	}
 
@@ -110,43 +126,62 @@ func (p PrModel) runQuery(query string, args []interface{}) error {
 	return nil
 }
 
-func (p PrModel) GetAll(userId int) ([]ConsolidatedPr, error) {
-	var prList []ConsolidatedPr
+// GetAll returns a page of the user's personal records. A zero filters.Filters
+// returns every record.
+func (p PrModel) GetAll(ctx context.Context, userId int, f filters.Filters) ([]ConsolidatedPr, filters.Metadata, error) {
+	prList := []ConsolidatedPr{}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Read)
 	defer cancel()
 
-	args := []interface{}{userId}
+	if len(f.SortSafelist) == 0 {
+		f.SortSafelist = []string{"exercise_id"}
+	}
+	query := fmt.Sprintf(selectPrByUserId, f.SortColumn(), f.SortDirection())
+	args := []interface{}{userId, f.FromArg(), f.ToArg(), f.Limit(), f.Offset()}
 
-	rows, err := p.db.QueryContext(ctx, selectPrByUserId, args...)
+	rows, err := p.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		fmt.Printf("error while fetching rows with user id: %d", userId)
-		return nil, err
+		p.logger.PrintDebug("selecting personal records failed", map[string]string{
+			"user_id": strconv.Itoa(userId),
+			"error":   err.Error(),
+		})
+		return nil, filters.Metadata{}, err
 	}
+	defer rows.Close()
 
+	totalRecords := 0
 	for rows.Next() {
-		// user_id, exercise_prs.exercise_id, exercise_name, exercise_description, pr
+		// user_id, exercise_prs.exercise_id, exercise_name, exercise_description, pr, achieved_at
 		var pr ConsolidatedPr
 		err = rows.Scan(
+			&totalRecords,
 			&pr.UserId,
 			&pr.ExerciseId,
 			&pr.ExerciseName,
 			&pr.ExerciseDescription,
-			&pr.PersonalRecord)
+			&pr.PersonalRecord,
+			&pr.AchievedAt)
 		if err != nil {
-			fmt.Printf("error while scanning row with user id: %d", userId)
-			return nil, err
+			p.logger.PrintDebug("scanning personal record failed", map[string]string{
+				"user_id": strconv.Itoa(userId),
+				"error":   err.Error(),
+			})
+			return nil, filters.Metadata{}, err
 		}
 
 		prList = append(prList, pr)
 	}
-	return prList, nil
+	if err = rows.Err(); err != nil {
+		return nil, filters.Metadata{}, err
+	}
+	return prList, filters.CalculateMetadata(totalRecords, f.Page, f.PageSize), nil
 }
 
-func (p PrModel) Get(userId int, exerciseId int) (*ConsolidatedPr, error) {
+func (p PrModel) Get(ctx context.Context, userId int, exerciseId int) (*ConsolidatedPr, error) {
 	pr := ConsolidatedPr{}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*7)
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Read)
 	defer cancel()
 
 	args := []interface{}{userId, exerciseId}
@@ -157,12 +192,16 @@ func (p PrModel) Get(userId int, exerciseId int) (*ConsolidatedPr, error) {
 		&pr.ExerciseId,
 		&pr.ExerciseName,
 		&pr.ExerciseDescription,
-		&pr.PersonalRecord)
+		&pr.PersonalRecord,
+		&pr.AchievedAt)
 
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			fmt.Printf("no pr for user id: %d and exercise id: %d \n", userId, exerciseId)
+			p.logger.PrintDebug("no personal record", map[string]string{
+				"user_id":     strconv.Itoa(userId),
+				"exercise_id": strconv.Itoa(exerciseId),
+			})
 			return nil, err
 		default:
 			return nil, err
@@ -0,0 +1,245 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+)
+
+// serialises PR maintenance per (user, exercise) until the end of the
+// transaction, so concurrent workouts cannot overwrite each other's record.
+const lockPrQuery = `SELECT pg_advisory_xact_lock($1::int, $2::int);`
+
+const selectCurrentPrQuery = `SELECT COALESCE(pr, 0), achieved_at, e1rm, e1rm_achieved_at FROM exercise_prs WHERE (user_id, exercise_id) = ($1, $2);`
+
+// the heaviest weight lifted and when it was first lifted
+const selectBestWeightQuery = `SELECT s.weight, MIN(workouts_table.created_at)
+FROM workouts_table, unnest(workouts_table.weights) AS s(weight)
+WHERE (workouts_table.user_id, workouts_table.exercise_id) = ($1, $2)
+GROUP BY s.weight
+ORDER BY s.weight DESC
+LIMIT 1;`
+
+// the best estimated one rep max and when it was first reached, see epley
+const selectBestE1rmQuery = `SELECT (CASE WHEN s.reps <= 1 THEN s.weight ELSE s.weight * (1 + s.reps / 30.0) END)::double precision AS e1rm,
+MIN(workouts_table.created_at)
+FROM workouts_table, unnest(workouts_table.weights, workouts_table.reps) AS s(weight, reps)
+WHERE (workouts_table.user_id, workouts_table.exercise_id) = ($1, $2) AND s.reps > 0
+GROUP BY e1rm
+ORDER BY e1rm DESC
+LIMIT 1;`
+
+const upsertPrQuery = `INSERT INTO exercise_prs (user_id, exercise_id, pr, achieved_at, e1rm, e1rm_achieved_at) VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (user_id, exercise_id) DO UPDATE SET (pr, achieved_at, e1rm, e1rm_achieved_at) =
+(EXCLUDED.pr, EXCLUDED.achieved_at, EXCLUDED.e1rm, EXCLUDED.e1rm_achieved_at);`
+
+// dbtx is the part of *sql.DB and *sql.Tx the models need, so that queries can
+// run either on their own or inside a transaction.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// PrChange describes how a personal record changed after workouts were
+// written. Previous is 0 when there was no record before and Current is 0 when
+// the record was removed because no workouts are left for the exercise, in
+// which case AchievedAt is nil.
+type PrChange struct {
+	PrKey
+	Previous   int        `json:"previous"`
+	Current    int        `json:"current"`
+	AchievedAt *time.Time `json:"achieved_at,omitempty"`
+}
+
+// prRecord is a personal record as stored, or as derived from the workouts.
+// The best estimated one rep max is kept with it for the e1rm leaderboard, it
+// is 0 with a zero E1rmAchievedAt when no set has any reps.
+type prRecord struct {
+	Pr             int
+	AchievedAt     time.Time
+	E1rm           float64
+	E1rmAchievedAt time.Time
+}
+
+// prStore is the storage the PR service works against. Keeping it behind an
+// interface lets the PR rules be exercised without a database.
+type prStore interface {
+	lock(ctx context.Context, key PrKey) error
+	current(ctx context.Context, key PrKey) (*prRecord, error)
+	best(ctx context.Context, key PrKey) (*prRecord, error)
+	save(ctx context.Context, key PrKey, record prRecord) error
+	remove(ctx context.Context, key PrKey) error
+}
+
+// prService keeps exercise_prs in step with workouts_table. A PR is always the
+// heaviest weight in the user's workouts for the exercise, so it goes down
+// when that workout is edited or deleted and disappears with the last workout.
+// The best e1rm of the exercise follows the same rules.
+type prService struct {
+	store prStore
+}
+
+func newPrService(q dbtx) prService {
+	return prService{store: sqlPrStore{q: q}}
+}
+
+// Recompute brings the PR and e1rm of every key up to date and returns the
+// PRs that changed. Keys are handled in a fixed order so that two transactions
+// recomputing overlapping keys cannot deadlock on the locks.
+func (s prService) Recompute(ctx context.Context, keys ...PrKey) ([]PrChange, error) {
+	keys = uniquePrKeys(keys)
+
+	var changes []PrChange
+	for _, key := range keys {
+		err := s.store.lock(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+
+		current, err := s.store.current(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		best, err := s.store.best(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+
+		change, changed := diffPr(key, current, best)
+		if !changed && !diffE1rm(current, best) {
+			continue
+		}
+
+		if best == nil {
+			err = s.store.remove(ctx, key)
+		} else {
+			err = s.store.save(ctx, key, keepDates(current, *best))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+// diffPr compares the stored record with the one derived from the workouts.
+// A record with the same weight is left alone so that it keeps its date.
+func diffPr(key PrKey, current, best *prRecord) (PrChange, bool) {
+	change := PrChange{PrKey: key}
+	if current != nil {
+		change.Previous = current.Pr
+	}
+	if best != nil {
+		change.Current = best.Pr
+		achievedAt := best.AchievedAt
+		change.AchievedAt = &achievedAt
+	}
+
+	switch {
+	case current == nil && best == nil:
+		return change, false
+	case current != nil && best != nil && current.Pr == best.Pr:
+		return change, false
+	}
+	return change, true
+}
+
+// diffE1rm reports whether the stored e1rm differs from the one derived from
+// the workouts. Like the PR, an e1rm of the same value keeps its date.
+func diffE1rm(current, best *prRecord) bool {
+	return current != nil && best != nil && current.E1rm != best.E1rm
+}
+
+// keepDates returns best with the dates of the stored record for the values
+// that did not change.
+func keepDates(current *prRecord, best prRecord) prRecord {
+	if current == nil {
+		return best
+	}
+	if current.Pr == best.Pr {
+		best.AchievedAt = current.AchievedAt
+	}
+	if current.E1rm == best.E1rm {
+		best.E1rmAchievedAt = current.E1rmAchievedAt
+	}
+	return best
+}
+
+func uniquePrKeys(keys []PrKey) []PrKey {
+	seen := make(map[PrKey]bool, len(keys))
+	unique := make([]PrKey, 0, len(keys))
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+	sort.Slice(unique, func(i, j int) bool {
+		if unique[i].UserId != unique[j].UserId {
+			return unique[i].UserId < unique[j].UserId
+		}
+		return unique[i].ExerciseId < unique[j].ExerciseId
+	})
+	return unique
+}
+
+type sqlPrStore struct {
+	q dbtx
+}
+
+func (s sqlPrStore) lock(ctx context.Context, key PrKey) error {
+	_, err := s.q.ExecContext(ctx, lockPrQuery, key.UserId, key.ExerciseId)
+	return err
+}
+
+func (s sqlPrStore) current(ctx context.Context, key PrKey) (*prRecord, error) {
+	var record prRecord
+	var e1rmAchievedAt sql.NullTime
+	err := s.q.QueryRowContext(ctx, selectCurrentPrQuery, key.UserId, key.ExerciseId).Scan(
+		&record.Pr,
+		&record.AchievedAt,
+		&record.E1rm,
+		&e1rmAchievedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	record.E1rmAchievedAt = e1rmAchievedAt.Time
+	return &record, nil
+}
+
+func (s sqlPrStore) best(ctx context.Context, key PrKey) (*prRecord, error) {
+	var record prRecord
+	err := s.q.QueryRowContext(ctx, selectBestWeightQuery, key.UserId, key.ExerciseId).Scan(&record.Pr, &record.AchievedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	err = s.q.QueryRowContext(ctx, selectBestE1rmQuery, key.UserId, key.ExerciseId).Scan(&record.E1rm, &record.E1rmAchievedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s sqlPrStore) save(ctx context.Context, key PrKey, record prRecord) error {
+	_, err := s.q.ExecContext(ctx, upsertPrQuery, key.UserId, key.ExerciseId, record.Pr, record.AchievedAt,
+		record.E1rm, sql.NullTime{Time: record.E1rmAchievedAt, Valid: !record.E1rmAchievedAt.IsZero()})
+	return err
+}
+
+func (s sqlPrStore) remove(ctx context.Context, key PrKey) error {
+	_, err := s.q.ExecContext(ctx, deletePrQuery, key.UserId, key.ExerciseId)
+	return err
+}
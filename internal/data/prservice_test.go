@@ -0,0 +1,309 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeWorkout struct {
+	key     PrKey
+	weights []int
+	// reps may be left out when the test is not about the e1rm
+	reps      []int
+	createdAt time.Time
+}
+
+// fakePrStore derives the best record from its workouts like the SQL store
+// does and records which keys were written, so the tests can check that
+// nothing outside the recomputed keys is touched.
+type fakePrStore struct {
+	workouts map[int]fakeWorkout
+	prs      map[PrKey]prRecord
+	locked   []PrKey
+	written  []PrKey
+}
+
+func (s *fakePrStore) lock(ctx context.Context, key PrKey) error {
+	s.locked = append(s.locked, key)
+	return nil
+}
+
+func (s *fakePrStore) current(ctx context.Context, key PrKey) (*prRecord, error) {
+	record, ok := s.prs[key]
+	if !ok {
+		return nil, nil
+	}
+	return &record, nil
+}
+
+func (s *fakePrStore) best(ctx context.Context, key PrKey) (*prRecord, error) {
+	var best *prRecord
+	for _, workout := range s.workouts {
+		if workout.key != key {
+			continue
+		}
+		for i, weight := range workout.weights {
+			switch {
+			case best == nil:
+				best = &prRecord{Pr: weight, AchievedAt: workout.createdAt}
+			case weight > best.Pr:
+				best.Pr, best.AchievedAt = weight, workout.createdAt
+			case weight == best.Pr && workout.createdAt.Before(best.AchievedAt):
+				best.AchievedAt = workout.createdAt
+			}
+
+			if i >= len(workout.reps) || workout.reps[i] <= 0 {
+				continue
+			}
+			e1rm := epley(weight, workout.reps[i])
+			switch {
+			case e1rm > best.E1rm:
+				best.E1rm, best.E1rmAchievedAt = e1rm, workout.createdAt
+			case e1rm == best.E1rm && workout.createdAt.Before(best.E1rmAchievedAt):
+				best.E1rmAchievedAt = workout.createdAt
+			}
+		}
+	}
+	return best, nil
+}
+
+func (s *fakePrStore) save(ctx context.Context, key PrKey, record prRecord) error {
+	s.written = append(s.written, key)
+	s.prs[key] = record
+	return nil
+}
+
+func (s *fakePrStore) remove(ctx context.Context, key PrKey) error {
+	s.written = append(s.written, key)
+	delete(s.prs, key)
+	return nil
+}
+
+func TestPrServiceRecompute(t *testing.T) {
+	day := func(n int) time.Time {
+		return time.Date(2024, 3, n, 9, 0, 0, 0, time.UTC)
+	}
+	bench := PrKey{UserId: 1, ExerciseId: 1}
+	squat := PrKey{UserId: 1, ExerciseId: 2}
+	otherBench := PrKey{UserId: 2, ExerciseId: 1}
+
+	tests := []struct {
+		name     string
+		workouts map[int]fakeWorkout
+		prs      map[PrKey]prRecord
+		// change is applied to the workouts before recomputing keys
+		change  func(workouts map[int]fakeWorkout)
+		keys    []PrKey
+		want    map[PrKey]prRecord
+		changes []PrChange
+	}{
+		{
+			name: "new max raises the PR",
+			workouts: map[int]fakeWorkout{
+				1: {key: bench, weights: []int{80, 90}, createdAt: day(1)},
+			},
+			prs: map[PrKey]prRecord{bench: {Pr: 90, AchievedAt: day(1)}},
+			change: func(workouts map[int]fakeWorkout) {
+				workouts[2] = fakeWorkout{key: bench, weights: []int{95, 100}, createdAt: day(2)}
+			},
+			keys:    []PrKey{bench},
+			want:    map[PrKey]prRecord{bench: {Pr: 100, AchievedAt: day(2)}},
+			changes: []PrChange{{PrKey: bench, Previous: 90, Current: 100, AchievedAt: ptr(day(2))}},
+		},
+		{
+			name: "editing the max set lowers the PR",
+			workouts: map[int]fakeWorkout{
+				1: {key: bench, weights: []int{80, 90}, createdAt: day(1)},
+				2: {key: bench, weights: []int{100}, createdAt: day(2)},
+			},
+			prs: map[PrKey]prRecord{bench: {Pr: 100, AchievedAt: day(2)}},
+			change: func(workouts map[int]fakeWorkout) {
+				workouts[2] = fakeWorkout{key: bench, weights: []int{85}, createdAt: day(2)}
+			},
+			keys:    []PrKey{bench},
+			want:    map[PrKey]prRecord{bench: {Pr: 90, AchievedAt: day(1)}},
+			changes: []PrChange{{PrKey: bench, Previous: 100, Current: 90, AchievedAt: ptr(day(1))}},
+		},
+		{
+			name: "deleting the last workout removes the PR",
+			workouts: map[int]fakeWorkout{
+				1: {key: bench, weights: []int{80}, createdAt: day(1)},
+			},
+			prs: map[PrKey]prRecord{bench: {Pr: 80, AchievedAt: day(1)}},
+			change: func(workouts map[int]fakeWorkout) {
+				delete(workouts, 1)
+			},
+			keys:    []PrKey{bench},
+			want:    map[PrKey]prRecord{},
+			changes: []PrChange{{PrKey: bench, Previous: 80, Current: 0}},
+		},
+		{
+			name: "moving a workout to another exercise recomputes both keys",
+			workouts: map[int]fakeWorkout{
+				1: {key: bench, weights: []int{60}, createdAt: day(1)},
+				2: {key: bench, weights: []int{120}, createdAt: day(2)},
+			},
+			prs: map[PrKey]prRecord{bench: {Pr: 120, AchievedAt: day(2)}},
+			change: func(workouts map[int]fakeWorkout) {
+				workouts[2] = fakeWorkout{key: squat, weights: []int{120}, createdAt: day(2)}
+			},
+			keys: []PrKey{squat, bench},
+			want: map[PrKey]prRecord{
+				bench: {Pr: 60, AchievedAt: day(1)},
+				squat: {Pr: 120, AchievedAt: day(2)},
+			},
+			changes: []PrChange{
+				{PrKey: bench, Previous: 120, Current: 60, AchievedAt: ptr(day(1))},
+				{PrKey: squat, Previous: 0, Current: 120, AchievedAt: ptr(day(2))},
+			},
+		},
+		{
+			name: "other users' rows are never touched",
+			workouts: map[int]fakeWorkout{
+				1: {key: bench, weights: []int{80}, createdAt: day(1)},
+				2: {key: otherBench, weights: []int{150}, createdAt: day(1)},
+			},
+			prs: map[PrKey]prRecord{
+				bench: {Pr: 80, AchievedAt: day(1)},
+				// deliberately stale, it must stay as it is
+				otherBench: {Pr: 200, AchievedAt: day(1)},
+			},
+			change: func(workouts map[int]fakeWorkout) {
+				delete(workouts, 1)
+			},
+			keys: []PrKey{bench},
+			want: map[PrKey]prRecord{
+				otherBench: {Pr: 200, AchievedAt: day(1)},
+			},
+			changes: []PrChange{{PrKey: bench, Previous: 80, Current: 0}},
+		},
+		{
+			name: "an unchanged max keeps its date",
+			workouts: map[int]fakeWorkout{
+				1: {key: bench, weights: []int{100}, createdAt: day(1)},
+			},
+			prs: map[PrKey]prRecord{bench: {Pr: 100, AchievedAt: day(1)}},
+			change: func(workouts map[int]fakeWorkout) {
+				workouts[2] = fakeWorkout{key: bench, weights: []int{100}, createdAt: day(2)}
+			},
+			keys: []PrKey{bench},
+			want: map[PrKey]prRecord{bench: {Pr: 100, AchievedAt: day(1)}},
+		},
+		{
+			name: "a better e1rm at the same max is saved without a PR change",
+			workouts: map[int]fakeWorkout{
+				1: {key: bench, weights: []int{100}, reps: []int{1}, createdAt: day(1)},
+			},
+			prs: map[PrKey]prRecord{bench: {Pr: 100, AchievedAt: day(1), E1rm: 100, E1rmAchievedAt: day(1)}},
+			change: func(workouts map[int]fakeWorkout) {
+				workouts[2] = fakeWorkout{key: bench, weights: []int{90}, reps: []int{10}, createdAt: day(2)}
+			},
+			keys: []PrKey{bench},
+			want: map[PrKey]prRecord{bench: {Pr: 100, AchievedAt: day(1), E1rm: 120, E1rmAchievedAt: day(2)}},
+		},
+		{
+			name: "a new max keeps the date of an unchanged e1rm",
+			workouts: map[int]fakeWorkout{
+				1: {key: bench, weights: []int{90}, reps: []int{10}, createdAt: day(1)},
+			},
+			prs: map[PrKey]prRecord{bench: {Pr: 90, AchievedAt: day(1), E1rm: 120, E1rmAchievedAt: day(1)}},
+			change: func(workouts map[int]fakeWorkout) {
+				workouts[2] = fakeWorkout{key: bench, weights: []int{100}, reps: []int{1}, createdAt: day(2)}
+			},
+			keys:    []PrKey{bench},
+			want:    map[PrKey]prRecord{bench: {Pr: 100, AchievedAt: day(2), E1rm: 120, E1rmAchievedAt: day(1)}},
+			changes: []PrChange{{PrKey: bench, Previous: 90, Current: 100, AchievedAt: ptr(day(2))}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakePrStore{workouts: tt.workouts, prs: tt.prs}
+			tt.change(store.workouts)
+
+			changes, err := prService{store: store}.Recompute(context.Background(), tt.keys...)
+			if err != nil {
+				t.Fatalf("Recompute: %v", err)
+			}
+
+			assertPrChanges(t, changes, tt.changes)
+			if len(store.prs) != len(tt.want) {
+				t.Errorf("got %d PRs, want %d: %v", len(store.prs), len(tt.want), store.prs)
+			}
+			for key, want := range tt.want {
+				got, ok := store.prs[key]
+				if !ok || got.Pr != want.Pr || !got.AchievedAt.Equal(want.AchievedAt) ||
+					got.E1rm != want.E1rm || !got.E1rmAchievedAt.Equal(want.E1rmAchievedAt) {
+					t.Errorf("PR of %+v = %+v, want %+v", key, got, want)
+				}
+			}
+
+			recomputed := make(map[PrKey]bool, len(tt.keys))
+			for _, key := range tt.keys {
+				recomputed[key] = true
+			}
+			for _, key := range append(store.locked, store.written...) {
+				if !recomputed[key] {
+					t.Errorf("touched %+v, which was not recomputed", key)
+				}
+			}
+		})
+	}
+}
+
+func TestPrServiceRecomputeLocksInOrder(t *testing.T) {
+	store := &fakePrStore{prs: map[PrKey]prRecord{}}
+	keys := []PrKey{{UserId: 2, ExerciseId: 1}, {UserId: 1, ExerciseId: 3}, {UserId: 1, ExerciseId: 2}, {UserId: 2, ExerciseId: 1}}
+
+	_, err := prService{store: store}.Recompute(context.Background(), keys...)
+	if err != nil {
+		t.Fatalf("Recompute: %v", err)
+	}
+
+	want := []PrKey{{UserId: 1, ExerciseId: 2}, {UserId: 1, ExerciseId: 3}, {UserId: 2, ExerciseId: 1}}
+	if len(store.locked) != len(want) {
+		t.Fatalf("locked %v, want %v", store.locked, want)
+	}
+	for i := range want {
+		if store.locked[i] != want[i] {
+			t.Fatalf("locked %v, want %v", store.locked, want)
+		}
+	}
+}
+
+func TestPrChangeRemovalOmitsAchievedAt(t *testing.T) {
+	js, err := json.Marshal(PrChange{PrKey: PrKey{UserId: 1, ExerciseId: 1}, Previous: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(js), "achieved_at") {
+		t.Errorf("removed PR serialised as %s", js)
+	}
+}
+
+func assertPrChanges(t *testing.T, got, want []PrChange) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d PR changes, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.PrKey != w.PrKey || g.Previous != w.Previous || g.Current != w.Current {
+			t.Errorf("change %d = %+v, want %+v", i, g, w)
+		}
+		switch {
+		case w.AchievedAt == nil && g.AchievedAt != nil:
+			t.Errorf("change %d achieved at %v, want none", i, *g.AchievedAt)
+		case w.AchievedAt != nil && (g.AchievedAt == nil || !g.AchievedAt.Equal(*w.AchievedAt)):
+			t.Errorf("change %d achieved at %v, want %v", i, g.AchievedAt, *w.AchievedAt)
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
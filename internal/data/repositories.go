@@ -0,0 +1,64 @@
+package data
+
+import (
+	"context"
+	"time"
+	"workout-microservice/internal/achievements"
+	"workout-microservice/internal/filters"
+	"workout-microservice/internal/training"
+)
+
+// The repositories are what the handlers see of the storage. WorkoutModel,
+// ExerciseModel and the other *Model structs implement them on Postgres, the
+// sqlite* types in sqlite.go on SQLite and the mem* types in memory.go in memory.
+
+type WorkoutRepository interface {
+	Insert(ctx context.Context, workout *Workout) ([]PrChange, []EarnedAchievement, error)
+	InsertBatch(ctx context.Context, workouts []*Workout) ([]PrChange, []EarnedAchievement, error)
+	Update(ctx context.Context, workout *Workout) ([]PrChange, []EarnedAchievement, error)
+	Delete(ctx context.Context, workoutId, version int) ([]PrChange, error)
+	GetByWorkoutId(ctx context.Context, workoutId int) ([]*Workout, error)
+	GetByUserIdAndExerciseId(ctx context.Context, userId, exerciseId int) ([]*Workout, error)
+	GetByUserId(ctx context.Context, userId int) ([]*Workout, error)
+	GetAll(ctx context.Context, userId, exerciseId int, f filters.Filters) ([]*Workout, filters.Metadata, error)
+	GetAllAfter(ctx context.Context, userId, exerciseId int, f filters.Filters, after filters.Cursor) ([]*Workout, *filters.Cursor, error)
+	DailyLoads(ctx context.Context, userId int, from, to time.Time) ([]training.DailyLoad, error)
+}
+
+type ExerciseRepository interface {
+	Insert(ctx context.Context, exercise *Exercise) error
+	Update(ctx context.Context, exercise *Exercise) error
+	Delete(ctx context.Context, id, version int) error
+	Select(ctx context.Context, id int) (*Exercise, error)
+	SelectAll(ctx context.Context, f filters.Filters) ([]Exercise, filters.Metadata, error)
+}
+
+type PrRepository interface {
+	Insert(ctx context.Context, pr Pr) error
+	Update(ctx context.Context, pr Pr) error
+	Delete(ctx context.Context, pr Pr) error
+	Get(ctx context.Context, userId int, exerciseId int) (*ConsolidatedPr, error)
+	GetAll(ctx context.Context, userId int, f filters.Filters) ([]ConsolidatedPr, filters.Metadata, error)
+	Rebuild(ctx context.Context, opts RebuildOptions, progress func(RebuildProgress)) (*RebuildReport, error)
+}
+
+type ProfileRepository interface {
+	Get(ctx context.Context, userId int) (*Profile, error)
+	Upsert(ctx context.Context, profile *Profile) error
+}
+
+type LeaderboardRepository interface {
+	Get(ctx context.Context, f LeaderboardFilter) (*Leaderboard, error)
+}
+
+type AchievementRepository interface {
+	Stats(ctx context.Context, userId int) (achievements.Stats, error)
+	GetAll(ctx context.Context, userId int) ([]EarnedAchievement, error)
+}
+
+type IdempotencyRepository interface {
+	Reserve(ctx context.Context, key string, fingerprint []byte, ttl time.Duration) (*StoredResponse, error)
+	Complete(ctx context.Context, key string, response StoredResponse) error
+	Release(ctx context.Context, key string) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
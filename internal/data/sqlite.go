@@ -0,0 +1,266 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"workout-microservice/internal/achievements"
+	"workout-microservice/internal/filters"
+	"workout-microservice/internal/jsonlog"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// The SQLite schema stores timestamps as unix seconds and the reps, weights
+// and plates arrays as JSON, so the queries that touch those columns have their
+// own SQLite versions. Exercises need nothing but a different page query.
+
+const selectAllExercisesSQLiteQuery = `SELECT count(*) OVER(), exercise_id, exercise_name, exercise_description, exercise_version FROM exercises
+ORDER BY %s %s, exercise_id ASC
+LIMIT $1 OFFSET $2;`
+
+const selectProfileSQLiteQuery = `SELECT user_id, bodyweight, sex, leaderboard_opt_in, unit, bar_weight, plates, updated_at
+FROM user_profiles WHERE user_id = $1;`
+
+const upsertProfileSQLiteQuery = `INSERT INTO user_profiles (user_id, bodyweight, sex, leaderboard_opt_in, unit, bar_weight, plates, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, unixepoch())
+ON CONFLICT (user_id) DO UPDATE SET bodyweight = excluded.bodyweight, sex = excluded.sex,
+leaderboard_opt_in = excluded.leaderboard_opt_in, unit = excluded.unit, bar_weight = excluded.bar_weight,
+plates = excluded.plates, updated_at = unixepoch()
+RETURNING updated_at;`
+
+const deleteExpiredIdempotencyKeySQLiteQuery = `DELETE FROM idempotency_keys WHERE idempotency_key = $1 AND expires_at < unixepoch();`
+
+const reserveIdempotencyKeySQLiteQuery = `INSERT INTO idempotency_keys (idempotency_key, fingerprint, expires_at)
+VALUES ($1, $2, unixepoch() + $3)
+ON CONFLICT (idempotency_key) DO NOTHING;`
+
+const deleteExpiredIdempotencyKeysSQLiteQuery = `DELETE FROM idempotency_keys WHERE expires_at < unixepoch();`
+
+// NewSQLiteModels returns the models of a SQLite database migrated with the
+// migrations in migrations/sqlite, for single-user and self-hosted setups.
+// Workouts are written together with the badges of rules they earn.
+func NewSQLiteModels(db *sql.DB, timeouts Timeouts, logger *jsonlog.Logger, rules []achievements.Rule) Models {
+	return Models{
+		WorkoutModel:     sqliteWorkoutModel{db: db, timeouts: timeouts, rules: rules},
+		ExerciseModel:    sqliteExerciseModel{ExerciseModel: ExerciseModel{db: db, timeouts: timeouts, logger: logger}},
+		PrModel:          sqlitePrModel{db: db, timeouts: timeouts},
+		ProfileModel:     sqliteProfileModel{db: db, timeouts: timeouts},
+		LeaderboardModel: sqliteLeaderboardModel{db: db, timeouts: timeouts},
+		AchievementModel: sqliteAchievementModel{db: db, timeouts: timeouts},
+		IdempotencyModel: sqliteIdempotencyModel{IdempotencyModel: IdempotencyModel{db: db, timeouts: timeouts}},
+	}
+}
+
+// unixTime scans a timestamp stored as unix seconds into t.
+type unixTime struct {
+	t *time.Time
+}
+
+func (u unixTime) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case int64:
+		*u.t = time.Unix(v, 0).UTC()
+	case nil:
+		*u.t = time.Time{}
+	default:
+		return fmt.Errorf("cannot scan %T into a unix timestamp", src)
+	}
+	return nil
+}
+
+// unixArg is the unix seconds argument for t, NULL for the zero time.
+func unixArg(t time.Time) sql.NullInt64 {
+	return sql.NullInt64{Int64: t.Unix(), Valid: !t.IsZero()}
+}
+
+// unixNullArg converts the nullable time arguments of the filters.
+func unixNullArg(t sql.NullTime) sql.NullInt64 {
+	return sql.NullInt64{Int64: t.Time.Unix(), Valid: t.Valid}
+}
+
+// sqliteLimit is the LIMIT argument of f, SQLite takes a negative limit as no
+// limit and rejects NULL.
+func sqliteLimit(f filters.Filters) int {
+	if f.PageSize <= 0 {
+		return -1
+	}
+	return f.PageSize
+}
+
+// jsonArray scans a JSON array column into the slice v points to.
+type jsonArray struct {
+	v interface{}
+}
+
+func (j jsonArray) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), j.v)
+	case []byte:
+		return json.Unmarshal(v, j.v)
+	case nil:
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into a JSON array", src)
+}
+
+// jsonArrayArg encodes values for a JSON array column, nil as an empty array.
+func jsonArrayArg[T any](values []T) string {
+	if values == nil {
+		return "[]"
+	}
+	encoded, _ := json.Marshal(values)
+	return string(encoded)
+}
+
+// sqliteConstraintError wraps constraint failures in ErrConstraintViolation
+// like the Postgres models do for SQLSTATE class 23.
+func sqliteConstraintError(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqlite3.SQLITE_CONSTRAINT {
+		return fmt.Errorf("%w: %s", ErrConstraintViolation, sqliteErr.Error())
+	}
+	return err
+}
+
+// sqliteExerciseModel runs the Postgres exercise queries, which SQLite
+// understands as they are, apart from the page query.
+type sqliteExerciseModel struct {
+	ExerciseModel
+}
+
+func (e sqliteExerciseModel) SelectAll(ctx context.Context, f filters.Filters) ([]Exercise, filters.Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeouts.Read)
+	defer cancel()
+
+	query := fmt.Sprintf(selectAllExercisesSQLiteQuery, f.SortColumn(), f.SortDirection())
+	rows, err := e.db.QueryContext(ctx, query, sqliteLimit(f), f.Offset())
+	if err != nil {
+		return nil, filters.Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	exercises := []Exercise{}
+
+	for rows.Next() {
+		var exercise Exercise
+		if err := rows.Scan(&totalRecords, &exercise.ExerciseID, &exercise.ExerciseName, &exercise.ExerciseDescription, &exercise.ExerciseVersion); err != nil {
+			return nil, filters.Metadata{}, err
+		}
+		exercises = append(exercises, exercise)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, filters.Metadata{}, err
+	}
+
+	return exercises, filters.CalculateMetadata(totalRecords, f.Page, f.PageSize), nil
+}
+
+type sqliteProfileModel struct {
+	db       *sql.DB
+	timeouts Timeouts
+}
+
+func (p sqliteProfileModel) Get(ctx context.Context, userId int) (*Profile, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Read)
+	defer cancel()
+
+	if userId < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	var profile Profile
+	var bodyweight sql.NullFloat64
+	var sex sql.NullString
+	var barWeight sql.NullFloat64
+
+	err := p.db.QueryRowContext(ctx, selectProfileSQLiteQuery, userId).Scan(
+		&profile.UserId,
+		&bodyweight,
+		&sex,
+		&profile.LeaderboardOptIn,
+		&profile.Unit,
+		&barWeight,
+		jsonArray{&profile.Plates},
+		unixTime{&profile.UpdatedAt})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	profile.Bodyweight = bodyweight.Float64
+	profile.Sex = sex.String
+	profile.BarWeight = barWeight.Float64
+	normalisePlates(&profile)
+
+	return &profile, nil
+}
+
+func (p sqliteProfileModel) Upsert(ctx context.Context, profile *Profile) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+
+	args := []interface{}{
+		profile.UserId,
+		sql.NullFloat64{Float64: profile.Bodyweight, Valid: profile.Bodyweight > 0},
+		sql.NullString{String: profile.Sex, Valid: profile.Sex != ""},
+		profile.LeaderboardOptIn,
+		profile.Unit,
+		sql.NullFloat64{Float64: profile.BarWeight, Valid: profile.BarWeight > 0},
+		jsonArrayArg(profile.Plates),
+	}
+
+	normalisePlates(profile)
+	return p.db.QueryRowContext(ctx, upsertProfileSQLiteQuery, args...).Scan(unixTime{&profile.UpdatedAt})
+}
+
+// sqliteIdempotencyModel shares the queries of IdempotencyModel except for the
+// ones that compute expiry times.
+type sqliteIdempotencyModel struct {
+	IdempotencyModel
+}
+
+func (m sqliteIdempotencyModel) Reserve(ctx context.Context, key string, fingerprint []byte, ttl time.Duration) (*StoredResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeouts.Write)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, deleteExpiredIdempotencyKeySQLiteQuery, key)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := m.db.ExecContext(ctx, reserveIdempotencyKeySQLiteQuery, key, fingerprint, int64(ttl.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 1 {
+		return nil, nil
+	}
+
+	return m.stored(ctx, key, fingerprint)
+}
+
+func (m sqliteIdempotencyModel) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeouts.Batch)
+	defer cancel()
+
+	res, err := m.db.ExecContext(ctx, deleteExpiredIdempotencyKeysSQLiteQuery)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,421 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"workout-microservice/internal/achievements"
+	"workout-microservice/internal/filters"
+)
+
+const insertPrSQLiteQuery = `INSERT INTO exercise_prs (user_id, exercise_id, pr) VALUES ($1, $2, $3)
+ON CONFLICT (user_id, exercise_id) DO UPDATE SET pr = excluded.pr, achieved_at = unixepoch();`
+
+const updatePrSQLiteQuery = `UPDATE exercise_prs SET pr = $1, achieved_at = unixepoch() WHERE (user_id, exercise_id) = ($2, $3);`
+
+// the exercise_id alias lets ORDER BY name it without being ambiguous
+const selectPrByUserIdSQLiteQuery = `SELECT count(*) OVER(), user_id, exercise_prs.exercise_id AS exercise_id, exercise_name, exercise_description, pr, achieved_at
+FROM exercise_prs JOIN exercises
+ON exercise_prs.exercise_id = exercises.exercise_id WHERE user_id = $1
+AND ($2 IS NULL OR achieved_at >= $2)
+AND ($3 IS NULL OR achieved_at < $3)
+ORDER BY %s %s, exercise_id ASC
+LIMIT $4 OFFSET $5;`
+
+const selectPrKeysSQLiteQuery = `SELECT user_id, exercise_id FROM workouts_table
+WHERE ($1 = 0 OR user_id = $1) AND ($2 = 0 OR exercise_id = $2)
+UNION
+SELECT user_id, exercise_id FROM exercise_prs
+WHERE ($1 = 0 OR user_id = $1) AND ($2 = 0 OR exercise_id = $2)
+ORDER BY user_id, exercise_id;`
+
+// the score queries follow the Postgres ones in leaderboards.go
+const prScoreSQLiteQuery = `SELECT user_id, CAST(pr AS REAL) AS score, achieved_at FROM exercise_prs
+WHERE exercise_id = $1
+AND ($7 IS NULL OR achieved_at >= $7)
+AND ($8 IS NULL OR achieved_at < $8)`
+
+const relativeScoreSQLiteQuery = `SELECT exercise_prs.user_id, pr / user_profiles.bodyweight AS score, achieved_at FROM exercise_prs
+JOIN user_profiles ON exercise_prs.user_id = user_profiles.user_id
+WHERE exercise_id = $1 AND user_profiles.bodyweight > 0
+AND ($7 IS NULL OR achieved_at >= $7)
+AND ($8 IS NULL OR achieved_at < $8)`
+
+const e1rmScoreSQLiteQuery = `SELECT user_id, e1rm AS score, e1rm_achieved_at AS achieved_at FROM exercise_prs
+WHERE exercise_id = $1 AND e1rm > 0
+AND ($7 IS NULL OR e1rm_achieved_at >= $7)
+AND ($8 IS NULL OR e1rm_achieved_at < $8)`
+
+const leaderboardSQLiteQuery = `WITH scores AS (%s),
+eligible AS (
+    SELECT scores.user_id, score, achieved_at, user_profiles.bodyweight FROM scores
+    LEFT JOIN user_profiles ON scores.user_id = user_profiles.user_id
+    WHERE (COALESCE(user_profiles.leaderboard_opt_in, 0) OR scores.user_id = $2)
+    AND ($3 = '' OR user_profiles.sex = $3)
+    AND ($4 = 0 OR user_profiles.bodyweight > $4)
+    AND ($5 = 0 OR user_profiles.bodyweight <= $5)
+),
+ranked AS (
+    SELECT user_id, score, achieved_at, bodyweight,
+    RANK() OVER (ORDER BY score DESC) AS rank,
+    count(*) OVER() AS total
+    FROM eligible
+)
+SELECT user_id, score, achieved_at, bodyweight, rank, total FROM ranked
+WHERE rank <= $6 OR user_id = $2
+ORDER BY rank, user_id;`
+
+var leaderboardScoreSQLiteQueries = map[string]string{
+	MetricPr:       prScoreSQLiteQuery,
+	MetricE1rm:     e1rmScoreSQLiteQuery,
+	MetricRelative: relativeScoreSQLiteQuery,
+}
+
+const selectAchievementTotalsSQLiteQuery = `SELECT count(*),
+COALESCE(SUM((SELECT SUM(w.value * r.value) FROM json_each(weights) AS w JOIN json_each(reps) AS r ON r.key = w.key)), 0)
+FROM workouts_table WHERE user_id = $1;`
+
+// weekday 0 moves to the Sunday ending the week, six days before is its Monday
+const selectAchievementWeeksSQLiteQuery = `SELECT DISTINCT unixepoch(date(created_at, 'unixepoch', 'weekday 0', '-6 days')) AS week
+FROM workouts_table WHERE user_id = $1
+ORDER BY week;`
+
+const selectVolumeReachedSQLiteQuery = `SELECT workout_id FROM (
+	SELECT workout_id, created_at, SUM((SELECT SUM(w.value * r.value) FROM json_each(weights) AS w JOIN json_each(reps) AS r ON r.key = w.key))
+		OVER (ORDER BY created_at, workout_id) AS volume
+	FROM workouts_table WHERE user_id = $1
+) AS running
+WHERE volume >= $2
+ORDER BY created_at, workout_id
+LIMIT 1;`
+
+const selectWeightReachedSQLiteQuery = `SELECT workouts_table.workout_id
+FROM workouts_table JOIN exercises ON workouts_table.exercise_id = exercises.exercise_id
+WHERE workouts_table.user_id = $1 AND lower(exercises.exercise_name) = lower($2)
+AND EXISTS (SELECT 1 FROM json_each(workouts_table.weights) AS w WHERE w.value >= $3)
+ORDER BY workouts_table.created_at, workouts_table.workout_id
+LIMIT 1;`
+
+const insertAchievementSQLiteQuery = `INSERT INTO achievements (user_id, code, workout_id) VALUES ($1, $2, $3)
+ON CONFLICT (user_id, code) DO NOTHING
+RETURNING earned_at;`
+
+type sqlitePrModel struct {
+	db       *sql.DB
+	timeouts Timeouts
+}
+
+func (p sqlitePrModel) Insert(ctx context.Context, pr Pr) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, insertPrSQLiteQuery, pr.UserId, pr.ExerciseId, pr.PersonalRecord)
+	return sqliteConstraintError(err)
+}
+
+func (p sqlitePrModel) Update(ctx context.Context, pr Pr) error {
+	return p.runQuery(ctx, updatePrSQLiteQuery, pr.PersonalRecord, pr.UserId, pr.ExerciseId)
+}
+
+func (p sqlitePrModel) Delete(ctx context.Context, pr Pr) error {
+	return p.runQuery(ctx, deletePrQuery, pr.UserId, pr.ExerciseId)
+}
+
+func (p sqlitePrModel) runQuery(ctx context.Context, query string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Write)
+	defer cancel()
+
+	res, err := p.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (p sqlitePrModel) Get(ctx context.Context, userId int, exerciseId int) (*ConsolidatedPr, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Read)
+	defer cancel()
+
+	var pr ConsolidatedPr
+	err := p.db.QueryRowContext(ctx, selectPrQueryByBoth, userId, exerciseId).Scan(
+		&pr.UserId,
+		&pr.ExerciseId,
+		&pr.ExerciseName,
+		&pr.ExerciseDescription,
+		&pr.PersonalRecord,
+		unixTime{&pr.AchievedAt})
+	if err != nil {
+		return nil, err
+	}
+	return &pr, nil
+}
+
+func (p sqlitePrModel) GetAll(ctx context.Context, userId int, f filters.Filters) ([]ConsolidatedPr, filters.Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.Read)
+	defer cancel()
+
+	if len(f.SortSafelist) == 0 {
+		f.SortSafelist = []string{"exercise_id"}
+	}
+	query := fmt.Sprintf(selectPrByUserIdSQLiteQuery, f.SortColumn(), f.SortDirection())
+	args := []interface{}{userId, unixNullArg(f.FromArg()), unixNullArg(f.ToArg()), sqliteLimit(f), f.Offset()}
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, filters.Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	prList := []ConsolidatedPr{}
+	for rows.Next() {
+		var pr ConsolidatedPr
+		err = rows.Scan(
+			&totalRecords,
+			&pr.UserId,
+			&pr.ExerciseId,
+			&pr.ExerciseName,
+			&pr.ExerciseDescription,
+			&pr.PersonalRecord,
+			unixTime{&pr.AchievedAt})
+		if err != nil {
+			return nil, filters.Metadata{}, err
+		}
+		prList = append(prList, pr)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, filters.Metadata{}, err
+	}
+	return prList, filters.CalculateMetadata(totalRecords, f.Page, f.PageSize), nil
+}
+
+func (p sqlitePrModel) Rebuild(ctx context.Context, opts RebuildOptions, progress func(RebuildProgress)) (*RebuildReport, error) {
+	return runRebuild(ctx, opts, progress, p.rebuildKeys, p.rebuildBatch)
+}
+
+func (p sqlitePrModel) rebuildKeys(ctx context.Context, opts RebuildOptions) ([]PrKey, error) {
+	rows, err := p.db.QueryContext(ctx, selectPrKeysSQLiteQuery, opts.UserId, opts.ExerciseId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []PrKey
+	for rows.Next() {
+		var key PrKey
+		err = rows.Scan(&key.UserId, &key.ExerciseId)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (p sqlitePrModel) rebuildBatch(ctx context.Context, keys []PrKey, dryRun bool) ([]PrChange, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	changes, err := newSQLitePrService(tx).Recompute(ctx, keys...)
+	if err != nil || dryRun {
+		return changes, err
+	}
+	return changes, tx.Commit()
+}
+
+type sqliteLeaderboardModel struct {
+	db       *sql.DB
+	timeouts Timeouts
+}
+
+func (l sqliteLeaderboardModel) Get(ctx context.Context, f LeaderboardFilter) (*Leaderboard, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.timeouts.Read)
+	defer cancel()
+
+	scoreQuery, ok := leaderboardScoreSQLiteQueries[f.Metric]
+	if !ok {
+		return nil, fmt.Errorf("unknown leaderboard metric %q", f.Metric)
+	}
+
+	var lower, upper float64
+	if f.WeightClass != "" {
+		lower, upper, _ = weightClassBounds(f.Sex, f.WeightClass)
+	}
+
+	args := []interface{}{
+		f.ExerciseId,
+		f.CallerId,
+		f.Sex,
+		lower,
+		upper,
+		f.Limit,
+		unixArg(f.From),
+		unixArg(f.To),
+	}
+
+	rows, err := l.db.QueryContext(ctx, fmt.Sprintf(leaderboardSQLiteQuery, scoreQuery), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	leaderboard := Leaderboard{
+		ExerciseId: f.ExerciseId,
+		Metric:     f.Metric,
+		Entries:    []LeaderboardEntry{},
+	}
+
+	for rows.Next() {
+		var entry LeaderboardEntry
+		var bodyweight sql.NullFloat64
+
+		err = rows.Scan(
+			&entry.UserId,
+			&entry.Score,
+			unixTime{&entry.AchievedAt},
+			&bodyweight,
+			&entry.Rank,
+			&leaderboard.TotalRanked)
+		if err != nil {
+			return nil, err
+		}
+		entry.Bodyweight = bodyweight.Float64
+
+		if entry.UserId == f.CallerId {
+			caller := entry
+			leaderboard.Caller = &caller
+		}
+		if entry.Rank <= f.Limit {
+			leaderboard.Entries = append(leaderboard.Entries, entry)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &leaderboard, nil
+}
+
+type sqliteAchievementModel struct {
+	db       *sql.DB
+	timeouts Timeouts
+}
+
+func (a sqliteAchievementModel) Stats(ctx context.Context, userId int) (achievements.Stats, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Read)
+	defer cancel()
+
+	return sqliteAchievementStore{q: a.db}.stats(ctx, userId)
+}
+
+func (a sqliteAchievementModel) GetAll(ctx context.Context, userId int) ([]EarnedAchievement, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeouts.Read)
+	defer cancel()
+
+	rows, err := a.db.QueryContext(ctx, selectAchievementsQuery, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	earned := []EarnedAchievement{}
+	for rows.Next() {
+		var achievement EarnedAchievement
+		var workoutId sql.NullInt64
+		err = rows.Scan(&achievement.UserId, &achievement.Code, &workoutId, unixTime{&achievement.EarnedAt})
+		if err != nil {
+			return nil, err
+		}
+		achievement.WorkoutId = int(workoutId.Int64)
+		earned = append(earned, achievement)
+	}
+	return earned, rows.Err()
+}
+
+// sqliteAchievementStore is the achievementStore of the SQLite backend.
+type sqliteAchievementStore struct {
+	q dbtx
+}
+
+func newSQLiteAchievementService(q dbtx, rules []achievements.Rule) achievementService {
+	return achievementService{store: sqliteAchievementStore{q: q}, rules: rules}
+}
+
+func (s sqliteAchievementStore) stats(ctx context.Context, userId int) (achievements.Stats, error) {
+	stats := achievements.Stats{}
+	err := s.q.QueryRowContext(ctx, selectAchievementTotalsSQLiteQuery, userId).Scan(&stats.WorkoutCount, &stats.TotalVolume)
+	if err != nil {
+		return achievements.Stats{}, err
+	}
+
+	stats.MaxWeights, err = queryMaxWeights(ctx, s.q, selectAchievementMaxWeightsQuery, userId)
+	if err != nil {
+		return achievements.Stats{}, err
+	}
+
+	rows, err := s.q.QueryContext(ctx, selectAchievementWeeksSQLiteQuery, userId)
+	if err != nil {
+		return achievements.Stats{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var week time.Time
+		if err = rows.Scan(unixTime{&week}); err != nil {
+			return achievements.Stats{}, err
+		}
+		stats.Weeks = append(stats.Weeks, week)
+	}
+	if err = rows.Err(); err != nil {
+		return achievements.Stats{}, err
+	}
+
+	stats.WeeklyStreak = achievements.LongestWeeklyStreak(stats.Weeks)
+	return stats, nil
+}
+
+func (s sqliteAchievementStore) earned(ctx context.Context, userId int) (map[string]bool, error) {
+	return queryEarnedCodes(ctx, s.q, selectEarnedCodesQuery, userId)
+}
+
+func (s sqliteAchievementStore) nthWorkout(ctx context.Context, userId, n int) (int, error) {
+	return queryWorkoutId(ctx, s.q, selectNthWorkoutQuery, userId, n-1)
+}
+
+func (s sqliteAchievementStore) volumeReached(ctx context.Context, userId, volume int) (int, error) {
+	return queryWorkoutId(ctx, s.q, selectVolumeReachedSQLiteQuery, userId, volume)
+}
+
+func (s sqliteAchievementStore) weightReached(ctx context.Context, userId int, exerciseName string, weight int) (int, error) {
+	return queryWorkoutId(ctx, s.q, selectWeightReachedSQLiteQuery, userId, exerciseName, weight)
+}
+
+func (s sqliteAchievementStore) firstWorkoutSince(ctx context.Context, userId int, since time.Time) (int, error) {
+	return queryWorkoutId(ctx, s.q, selectFirstWorkoutSinceQuery, userId, unixArg(since))
+}
+
+func (s sqliteAchievementStore) award(ctx context.Context, userId int, code string, workoutId int) (*EarnedAchievement, error) {
+	achievement := EarnedAchievement{UserId: userId, Code: code, WorkoutId: workoutId}
+	workout := sql.NullInt64{Int64: int64(workoutId), Valid: workoutId > 0}
+	err := s.q.QueryRowContext(ctx, insertAchievementSQLiteQuery, userId, code, workout).Scan(unixTime{&achievement.EarnedAt})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// earned before
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &achievement, nil
+}
@@ -0,0 +1,454 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"workout-microservice/internal/achievements"
+	"workout-microservice/internal/filters"
+	"workout-microservice/internal/training"
+)
+
+const insertWorkoutSQLiteQuery = `INSERT INTO workouts_table (user_id, exercise_id, duration, sets, reps, weights, rpe, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, COALESCE($8, unixepoch()))
+RETURNING workout_id, created_at, version;`
+
+// transactions take the write lock when they begin (_txlock=immediate), so
+// unlike on Postgres there is no row lock to take here.
+const selectWorkoutKeySQLiteQuery = `SELECT user_id, exercise_id FROM workouts_table WHERE workout_id = $1;`
+
+const selectWorkoutsPageSQLiteQuery = `SELECT count(*) OVER(), ` + selectWorkoutColumns + `
+FROM workouts_table WHERE user_id = $1 AND ($2 = 0 OR exercise_id = $2)
+AND ($3 IS NULL OR created_at >= $3)
+AND ($4 IS NULL OR created_at < $4)
+ORDER BY %s %s, workout_id ASC
+LIMIT $5 OFFSET $6;`
+
+const selectWorkoutsKeysetSQLiteQuery = `SELECT ` + selectWorkoutColumns + `
+FROM workouts_table WHERE user_id = $1 AND ($2 = 0 OR exercise_id = $2)
+AND ($3 IS NULL OR (created_at, workout_id) %s ($3, $4))
+AND ($5 IS NULL OR created_at >= $5)
+AND ($6 IS NULL OR created_at < $6)
+ORDER BY created_at %s, workout_id %s
+LIMIT $7;`
+
+const selectDailyLoadSQLiteQuery = `SELECT unixepoch(date(created_at, 'unixepoch')) AS day, count(*),
+COALESCE(SUM(rpe * duration), 0),
+COALESCE(SUM((SELECT SUM(w.value * r.value) FROM json_each(weights) AS w JOIN json_each(reps) AS r ON r.key = w.key)), 0)
+FROM workouts_table WHERE user_id = $1 AND created_at >= $2 AND created_at < $3
+GROUP BY day ORDER BY day;`
+
+const selectCurrentPrSQLiteQuery = `SELECT COALESCE(pr, 0), achieved_at, e1rm, e1rm_achieved_at FROM exercise_prs WHERE (user_id, exercise_id) = ($1, $2);`
+
+const selectBestWeightSQLiteQuery = `SELECT w.value, MIN(workouts_table.created_at)
+FROM workouts_table, json_each(workouts_table.weights) AS w
+WHERE (workouts_table.user_id, workouts_table.exercise_id) = ($1, $2)
+GROUP BY w.value
+ORDER BY w.value DESC
+LIMIT 1;`
+
+const selectBestE1rmSQLiteQuery = `SELECT CASE WHEN r.value <= 1 THEN CAST(w.value AS REAL) ELSE w.value * (1 + r.value / 30.0) END AS e1rm,
+MIN(workouts_table.created_at)
+FROM workouts_table, json_each(workouts_table.weights) AS w JOIN json_each(workouts_table.reps) AS r ON r.key = w.key
+WHERE (workouts_table.user_id, workouts_table.exercise_id) = ($1, $2) AND r.value > 0
+GROUP BY e1rm
+ORDER BY e1rm DESC
+LIMIT 1;`
+
+const upsertPrSQLiteQuery = `INSERT INTO exercise_prs (user_id, exercise_id, pr, achieved_at, e1rm, e1rm_achieved_at) VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (user_id, exercise_id) DO UPDATE SET pr = excluded.pr, achieved_at = excluded.achieved_at,
+e1rm = excluded.e1rm, e1rm_achieved_at = excluded.e1rm_achieved_at;`
+
+// sqlitePrStore is the prStore of the SQLite backend. The transaction it runs
+// in already holds the database write lock, so lock has nothing left to do.
+type sqlitePrStore struct {
+	q dbtx
+}
+
+func newSQLitePrService(q dbtx) prService {
+	return prService{store: sqlitePrStore{q: q}}
+}
+
+func (s sqlitePrStore) lock(context.Context, PrKey) error {
+	return nil
+}
+
+func (s sqlitePrStore) current(ctx context.Context, key PrKey) (*prRecord, error) {
+	var record prRecord
+	err := s.q.QueryRowContext(ctx, selectCurrentPrSQLiteQuery, key.UserId, key.ExerciseId).Scan(
+		&record.Pr,
+		unixTime{&record.AchievedAt},
+		&record.E1rm,
+		unixTime{&record.E1rmAchievedAt})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s sqlitePrStore) best(ctx context.Context, key PrKey) (*prRecord, error) {
+	var record prRecord
+	err := s.q.QueryRowContext(ctx, selectBestWeightSQLiteQuery, key.UserId, key.ExerciseId).Scan(&record.Pr, unixTime{&record.AchievedAt})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	err = s.q.QueryRowContext(ctx, selectBestE1rmSQLiteQuery, key.UserId, key.ExerciseId).Scan(&record.E1rm, unixTime{&record.E1rmAchievedAt})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s sqlitePrStore) save(ctx context.Context, key PrKey, record prRecord) error {
+	_, err := s.q.ExecContext(ctx, upsertPrSQLiteQuery, key.UserId, key.ExerciseId, record.Pr, record.AchievedAt.Unix(),
+		record.E1rm, unixArg(record.E1rmAchievedAt))
+	return err
+}
+
+func (s sqlitePrStore) remove(ctx context.Context, key PrKey) error {
+	_, err := s.q.ExecContext(ctx, deletePrQuery, key.UserId, key.ExerciseId)
+	return err
+}
+
+type sqliteWorkoutModel struct {
+	db       *sql.DB
+	timeouts Timeouts
+	rules    []achievements.Rule
+}
+
+// insert writes one workout inside tx and fills in its id, creation time and version.
+func (w sqliteWorkoutModel) insert(ctx context.Context, tx *sql.Tx, workout *Workout) error {
+	args := []interface{}{
+		workout.UserId,
+		workout.ExerciseId,
+		workout.Duration,
+		workout.Sets,
+		jsonArrayArg(workout.Reps),
+		jsonArrayArg(workout.Weights),
+		nullRpe(workout.Rpe),
+		unixArg(workout.CreatedAt),
+	}
+
+	err := tx.QueryRowContext(ctx, insertWorkoutSQLiteQuery, args...).Scan(
+		&workout.WorkoutId,
+		unixTime{&workout.CreatedAt},
+		&workout.Version)
+	return sqliteConstraintError(err)
+}
+
+func (w sqliteWorkoutModel) Insert(ctx context.Context, workout *Workout) ([]PrChange, []EarnedAchievement, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeouts.Write)
+	defer cancel()
+
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	err = w.insert(ctx, tx, workout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changes, err := newSQLitePrService(tx).Recompute(ctx, PrKey{UserId: workout.UserId, ExerciseId: workout.ExerciseId})
+	if err != nil {
+		return nil, nil, err
+	}
+	earned, err := newSQLiteAchievementService(tx, w.rules).Award(ctx, workout.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return changes, earned, tx.Commit()
+}
+
+func (w sqliteWorkoutModel) InsertBatch(ctx context.Context, workouts []*Workout) ([]PrChange, []EarnedAchievement, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeouts.Batch)
+	defer cancel()
+
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	affected := make([]PrKey, 0, len(workouts))
+	userIds := make([]int, 0, len(workouts))
+	var batchErr BatchError
+
+	// a failing statement does not abort a SQLite transaction, so the rest of
+	// the batch is still checked after a rejected workout
+	for i, workout := range workouts {
+		err = w.insert(ctx, tx, workout)
+		if err != nil {
+			if !batchErr.add(i, err) {
+				return nil, nil, &BatchItemError{Index: i, Err: err}
+			}
+			continue
+		}
+		affected = append(affected, PrKey{UserId: workout.UserId, ExerciseId: workout.ExerciseId})
+		userIds = append(userIds, workout.UserId)
+	}
+	if err = batchErr.err(); err != nil {
+		return nil, nil, err
+	}
+
+	changes, err := newSQLitePrService(tx).Recompute(ctx, affected...)
+	if err != nil {
+		return nil, nil, err
+	}
+	earned, err := newSQLiteAchievementService(tx, w.rules).Award(ctx, userIds...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return changes, earned, tx.Commit()
+}
+
+func (w sqliteWorkoutModel) Update(ctx context.Context, workout *Workout) ([]PrChange, []EarnedAchievement, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeouts.Write)
+	defer cancel()
+
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var previous PrKey
+	err = tx.QueryRowContext(ctx, selectWorkoutKeySQLiteQuery, workout.WorkoutId).Scan(&previous.UserId, &previous.ExerciseId)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrEditConflict
+		default:
+			return nil, nil, err
+		}
+	}
+
+	args := []interface{}{
+		workout.UserId,
+		workout.ExerciseId,
+		workout.Duration,
+		workout.Sets,
+		jsonArrayArg(workout.Reps),
+		jsonArrayArg(workout.Weights),
+		workout.WorkoutId,
+		nullRpe(workout.Rpe),
+		workout.Version,
+	}
+
+	err = tx.QueryRowContext(ctx, updateWorkQuery, args...).Scan(&workout.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrEditConflict
+		default:
+			return nil, nil, sqliteConstraintError(err)
+		}
+	}
+
+	changes, err := newSQLitePrService(tx).Recompute(ctx, previous, PrKey{UserId: workout.UserId, ExerciseId: workout.ExerciseId})
+	if err != nil {
+		return nil, nil, err
+	}
+	earned, err := newSQLiteAchievementService(tx, w.rules).Award(ctx, workout.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return changes, earned, tx.Commit()
+}
+
+func (w sqliteWorkoutModel) Delete(ctx context.Context, workoutId, version int) ([]PrChange, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeouts.Write)
+	defer cancel()
+
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var key PrKey
+	err = tx.QueryRowContext(ctx, deleteWorkoutQuery, workoutId, version).Scan(&key.UserId, &key.ExerciseId)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	changes, err := newSQLitePrService(tx).Recompute(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, tx.Commit()
+}
+
+func (w sqliteWorkoutModel) GetByWorkoutId(ctx context.Context, workoutId int) ([]*Workout, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeouts.Read)
+	defer cancel()
+
+	var workout Workout
+	err := scanSQLiteWorkout(w.db.QueryRowContext(ctx, selectWorkQuery, workoutId), &workout)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return []*Workout{&workout}, nil
+}
+
+func (w sqliteWorkoutModel) GetByUserIdAndExerciseId(ctx context.Context, userId, exerciseId int) ([]*Workout, error) {
+	return w.queryWorkouts(ctx, selectAllWorkQuery, userId, exerciseId)
+}
+
+func (w sqliteWorkoutModel) GetByUserId(ctx context.Context, userId int) ([]*Workout, error) {
+	return w.queryWorkouts(ctx, selectWorkoutByUserId, userId)
+}
+
+func (w sqliteWorkoutModel) GetAll(ctx context.Context, userId, exerciseId int, f filters.Filters) ([]*Workout, filters.Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeouts.Read)
+	defer cancel()
+
+	query := fmt.Sprintf(selectWorkoutsPageSQLiteQuery, f.SortColumn(), f.SortDirection())
+	args := []interface{}{userId, exerciseId, unixNullArg(f.FromArg()), unixNullArg(f.ToArg()), sqliteLimit(f), f.Offset()}
+
+	rows, err := w.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, filters.Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	workouts := []*Workout{}
+
+	for rows.Next() {
+		var workout Workout
+		err = scanSQLiteWorkout(rows, &workout, &totalRecords)
+		if err != nil {
+			return nil, filters.Metadata{}, err
+		}
+		workouts = append(workouts, &workout)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, filters.Metadata{}, err
+	}
+
+	return workouts, filters.CalculateMetadata(totalRecords, f.Page, f.PageSize), nil
+}
+
+func (w sqliteWorkoutModel) GetAllAfter(ctx context.Context, userId, exerciseId int, f filters.Filters, after filters.Cursor) ([]*Workout, *filters.Cursor, error) {
+	comparison, direction := ">", "ASC"
+	if after.Descending {
+		comparison, direction = "<", "DESC"
+	}
+	query := fmt.Sprintf(selectWorkoutsKeysetSQLiteQuery, comparison, direction, direction)
+
+	afterTime, afterId := after.CursorArgs()
+	// one extra row tells whether there is a next page
+	args := []interface{}{userId, exerciseId, unixNullArg(afterTime), afterId, unixNullArg(f.FromArg()), unixNullArg(f.ToArg()), f.PageSize + 1}
+
+	workouts, err := w.queryWorkouts(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if workouts == nil {
+		workouts = []*Workout{}
+	}
+
+	if len(workouts) <= f.PageSize {
+		return workouts, nil, nil
+	}
+
+	workouts = workouts[:f.PageSize]
+	last := workouts[len(workouts)-1]
+	next := filters.Cursor{CreatedAt: last.CreatedAt, Id: last.WorkoutId, Descending: after.Descending}
+	return workouts, &next, nil
+}
+
+func (w sqliteWorkoutModel) DailyLoads(ctx context.Context, userId int, from, to time.Time) ([]training.DailyLoad, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeouts.Read)
+	defer cancel()
+
+	rows, err := w.db.QueryContext(ctx, selectDailyLoadSQLiteQuery, userId, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var loads []training.DailyLoad
+	for rows.Next() {
+		var load training.DailyLoad
+		err = rows.Scan(unixTime{&load.Date}, &load.Sessions, &load.SessionRpeLoad, &load.VolumeLoad)
+		if err != nil {
+			return nil, err
+		}
+		loads = append(loads, load)
+	}
+	return loads, rows.Err()
+}
+
+func (w sqliteWorkoutModel) queryWorkouts(ctx context.Context, query string, args ...interface{}) ([]*Workout, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeouts.Read)
+	defer cancel()
+
+	rows, err := w.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var workouts []*Workout
+
+	for rows.Next() {
+		var workout Workout
+		err = scanSQLiteWorkout(rows, &workout)
+		if err != nil {
+			return nil, err
+		}
+		workouts = append(workouts, &workout)
+	}
+
+	return workouts, rows.Err()
+}
+
+// scanSQLiteWorkout is scanWorkout for the SQLite column types.
+func scanSQLiteWorkout(row rowScanner, workout *Workout, leading ...interface{}) error {
+	var rpe sql.NullFloat64
+
+	dest := append(leading,
+		&workout.WorkoutId,
+		&workout.ExerciseId,
+		&workout.UserId,
+		&workout.Duration,
+		&workout.Sets,
+		jsonArray{&workout.Reps},
+		jsonArray{&workout.Weights},
+		unixTime{&workout.CreatedAt},
+		&rpe,
+		&workout.Version)
+	err := row.Scan(dest...)
+	if err != nil {
+		return err
+	}
+
+	workout.Rpe = rpe.Float64
+	return nil
+}
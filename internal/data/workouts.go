@@ -6,12 +6,19 @@ import (
 	"errors"
 	"fmt"
 	"github.com/lib/pq"
+	"strconv"
 	"time"
+	"workout-microservice/internal/achievements"
+	"workout-microservice/internal/filters"
+	"workout-microservice/internal/jsonlog"
 	"workout-microservice/internal/validator"
 )
 
 type WorkoutModel struct {
-	db *sql.DB
+	db       *sql.DB
+	timeouts Timeouts
+	logger   *jsonlog.Logger
+	rules    []achievements.Rule
 }
 
 const insertWorkoutQuery = `INSERT INTO workouts_table(
@@ -20,29 +27,58 @@ const insertWorkoutQuery = `INSERT INTO workouts_table(
                            duration, 
                            sets, 
                            reps, 
-                           weights) VALUES(
-                                 $1, $2, $3, $4, $5, $6         
-                           );`
+                           weights,
+                           rpe,
+                           created_at) VALUES(
+                                 $1, $2, $3, $4, $5, $6, $7, COALESCE($8::timestamptz, NOW())
+                           ) RETURNING workout_id, created_at, version;`
 
-const deleteWorkoutQuery = `DELETE FROM workouts_table WHERE workout_id = $1;`
+const deleteWorkoutQuery = `DELETE FROM workouts_table WHERE workout_id = $1 AND version = $2
+RETURNING user_id, exercise_id;`
+
+// the exercise a workout is logged under before an update, which may move it
+// to another exercise
+const selectWorkoutKeyQuery = `SELECT user_id, exercise_id FROM workouts_table WHERE workout_id = $1 FOR UPDATE;`
 
 const updateWorkQuery = `UPDATE workouts_table SET (
                            exercise_id, 
                            duration, 
                            sets, 
                            reps, 
-                           weights) = (
-                                 $2, $3, $4, $5, $6         
-                           ) WHERE (workout_id, user_id) = ($7, $1);`
+                           weights,
+                           rpe,
+                           version) = (
+                                 $2, $3, $4, $5, $6, $8, version + 1
+                           ) WHERE (workout_id, user_id) = ($7, $1) AND version = $9
+                           RETURNING version;`
+
+const selectWorkoutColumns = `workout_id, exercise_id, user_id, duration, sets, reps, weights, created_at, rpe, version`
 
-const selectAllWorkQuery = `SELECT workout_id, exercise_id, user_id, duration, sets, reps, weights, created_at
-FROM workouts_table WHERE (user_id, exercise_id) = ($1, $2);`
+const selectAllWorkQuery = `SELECT ` + selectWorkoutColumns + `
+FROM workouts_table WHERE (user_id, exercise_id) = ($1, $2) ORDER BY created_at, workout_id;`
 
-const selectWorkQuery = `SELECT workout_id, exercise_id, user_id, duration, sets, reps, weights, created_at
+const selectWorkQuery = `SELECT ` + selectWorkoutColumns + `
 FROM workouts_table WHERE workout_id = $1;`
 
-const selectWorkoutByUserId = `SELECT workout_id, user_id, exercise_id, duration, sets, reps, weights, created_at
-FROM workouts_table WHERE user_id = $1;`
+const selectWorkoutByUserId = `SELECT ` + selectWorkoutColumns + `
+FROM workouts_table WHERE user_id = $1 ORDER BY created_at, workout_id;`
+
+const selectWorkoutsPageQuery = `SELECT count(*) OVER(), ` + selectWorkoutColumns + `
+FROM workouts_table WHERE user_id = $1 AND ($2::bigint = 0 OR exercise_id = $2)
+AND ($3::timestamptz IS NULL OR created_at >= $3)
+AND ($4::timestamptz IS NULL OR created_at < $4)
+ORDER BY %s %s, workout_id ASC
+LIMIT $5 OFFSET $6;`
+
+// the keyset queries walk (created_at, workout_id) in one direction, %s is
+// always one of the fixed comparison/direction pairs in GetAllAfter.
+const selectWorkoutsKeysetQuery = `SELECT ` + selectWorkoutColumns + `
+FROM workouts_table WHERE user_id = $1 AND ($2::bigint = 0 OR exercise_id = $2)
+AND ($3::timestamptz IS NULL OR (created_at, workout_id) %s ($3, $4))
+AND ($5::timestamptz IS NULL OR created_at >= $5)
+AND ($6::timestamptz IS NULL OR created_at < $6)
+ORDER BY created_at %s, workout_id %s
+LIMIT $7;`
 
 type Workout struct {
 	WorkoutId  int       `json:"workout_id"`
@@ -53,14 +89,23 @@ type Workout struct {
 	Sets       int       `json:"sets"`
 	Reps       []int     `json:"reps"`
 	Weights    []int     `json:"weights"`
+	Rpe        float64   `json:"rpe,omitempty"`
+	Version    int       `json:"version"`
 }
 
-func (w WorkoutModel) Insert(workout *Workout) error {
-	fmt.Println(workout)
-	fmt.Println("Hello from insert function!")
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+// Insert saves the workout and updates the user's PR for the exercise in the
+// same transaction, returning the PR change if the workout set a new record
+// and the badges the user earned with it.
+func (w WorkoutModel) Insert(ctx context.Context, workout *Workout) ([]PrChange, []EarnedAchievement, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeouts.Write)
 	defer cancel()
 
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
 	args := []interface{}{
 		workout.UserId,
 		workout.ExerciseId,
@@ -68,54 +113,94 @@ func (w WorkoutModel) Insert(workout *Workout) error {
 		workout.Sets,
 		pq.Array(workout.Reps),
 		pq.Array(workout.Weights),
+		nullRpe(workout.Rpe),
+		sql.NullTime{Time: workout.CreatedAt, Valid: !workout.CreatedAt.IsZero()},
 	}
 
-	rowsAffected, err := w.db.ExecContext(ctx, insertWorkoutQuery, args...)
+	err = tx.QueryRowContext(ctx, insertWorkoutQuery, args...).Scan(&workout.WorkoutId, &workout.CreatedAt, &workout.Version)
 	if err != nil {
-		fmt.Println(err)
-		return err
+		w.logger.PrintDebug("inserting workout failed", map[string]string{
+			"user_id":     strconv.Itoa(workout.UserId),
+			"exercise_id": strconv.Itoa(workout.ExerciseId),
+			"error":       err.Error(),
+		})
+		return nil, nil, err
 	}
 
-	res, err := rowsAffected.RowsAffected()
+	changes, err := newPrService(tx).Recompute(ctx, PrKey{UserId: workout.UserId, ExerciseId: workout.ExerciseId})
 	if err != nil {
-		fmt.Println(err)
-		return err
+		return nil, nil, err
 	}
-
-	if res == 0 {
-		return errors.New("row not inserted due to" + err.Error())
+	earned, err := newAchievementService(tx, w.rules).Award(ctx, workout.UserId)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return nil
+	return changes, earned, tx.Commit()
 }
 
-func (w WorkoutModel) Delete(workoutId int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+// Delete removes the workout if it is still at the given version, returning
+// ErrEditConflict when it was changed or removed in the meantime. The PR for
+// the exercise is recomputed, and removed with the last workout.
+func (w WorkoutModel) Delete(ctx context.Context, workoutId, version int) ([]PrChange, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeouts.Write)
 	defer cancel()
 
-	args := []interface{}{workoutId}
-	rowsAffected, err := w.db.ExecContext(ctx, deleteWorkoutQuery, args...)
+	tx, err := w.db.BeginTx(ctx, nil)
 	if err != nil {
-		fmt.Println("error occurred while deleting row" + err.Error())
-		return err
+		return nil, err
 	}
-	res, err := rowsAffected.RowsAffected()
+	defer tx.Rollback()
+
+	var key PrKey
+	args := []interface{}{workoutId, version}
+	err = tx.QueryRowContext(ctx, deleteWorkoutQuery, args...).Scan(&key.UserId, &key.ExerciseId)
 	if err != nil {
-		fmt.Println("error while fetching affected rows" + err.Error())
-		return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			w.logger.PrintDebug("deleting workout failed", map[string]string{
+				"workout_id": strconv.Itoa(workoutId),
+				"error":      err.Error(),
+			})
+			return nil, err
+		}
 	}
 
-	if res == 0 {
-		return ErrRecordNotFound
+	changes, err := newPrService(tx).Recompute(ctx, key)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return changes, tx.Commit()
 }
 
-func (w WorkoutModel) Update(workout *Workout) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+// Update saves the workout if it is still at workout.Version and bumps the
+// version, returning ErrEditConflict when someone else updated it first. The
+// PRs of the exercise before and after the edit are recomputed and the badges
+// the edit earned are awarded.
+func (w WorkoutModel) Update(ctx context.Context, workout *Workout) ([]PrChange, []EarnedAchievement, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeouts.Write)
 	defer cancel()
 
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var previous PrKey
+	err = tx.QueryRowContext(ctx, selectWorkoutKeyQuery, workout.WorkoutId).Scan(&previous.UserId, &previous.ExerciseId)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrEditConflict
+		default:
+			return nil, nil, err
+		}
+	}
+
 	args := []interface{}{
 		workout.UserId,
 		workout.ExerciseId,
@@ -124,136 +209,200 @@ func (w WorkoutModel) Update(workout *Workout) error {
 		pq.Array(workout.Reps),
 		pq.Array(workout.Weights),
 		workout.WorkoutId,
+		nullRpe(workout.Rpe),
+		workout.Version,
 	}
 
-	res, err := w.db.ExecContext(ctx, updateWorkQuery, args...)
+	err = tx.QueryRowContext(ctx, updateWorkQuery, args...).Scan(&workout.Version)
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrEditConflict
+		default:
+			return nil, nil, err
+		}
 	}
-	rowsAffected, err := res.RowsAffected()
+
+	changes, err := newPrService(tx).Recompute(ctx, previous, PrKey{UserId: workout.UserId, ExerciseId: workout.ExerciseId})
 	if err != nil {
-		return errors.New("error while getting rows affected")
+		return nil, nil, err
 	}
-	if rowsAffected <= 0 {
-		return ErrRecordNotFound
+	earned, err := newAchievementService(tx, w.rules).Award(ctx, workout.UserId)
+	if err != nil {
+		return nil, nil, err
 	}
-	return nil
+
+	return changes, earned, tx.Commit()
 }
 
-func (w WorkoutModel) GetByWorkoutId(workoutId int) ([]*Workout, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+func (w WorkoutModel) GetByWorkoutId(ctx context.Context, workoutId int) ([]*Workout, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeouts.Read)
 	defer cancel()
 	var workouts []*Workout
 	var workout Workout
-	var reps64 []int64
-	var weights64 []int64
 	args := []interface{}{workoutId}
-	err := w.db.QueryRowContext(ctx, selectWorkQuery, args...).Scan(
-		&workout.WorkoutId,
-		&workout.ExerciseId,
-		&workout.UserId,
-		&workout.Duration,
-		&workout.Sets,
-		pq.Array(&reps64),
-		pq.Array(&weights64),
-		&workout.CreatedAt,
-	)
+	err := scanWorkout(w.db.QueryRowContext(ctx, selectWorkQuery, args...), &workout)
 	if err != nil {
-		fmt.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		w.logger.PrintDebug("selecting workout failed", map[string]string{
+			"workout_id": strconv.Itoa(workoutId),
+			"error":      err.Error(),
+		})
 		return nil, err
 	}
-	for i := range weights64 {
-		workout.Weights = append(workout.Weights, int(weights64[i]))
-		workout.Reps = append(workout.Reps, int(reps64[i]))
-	}
 
 	return append(workouts, &workout), nil
 }
 
-func (w WorkoutModel) GetByUserIdAndExerciseId(userId, exerciseId int) ([]*Workout, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+func (w WorkoutModel) GetByUserIdAndExerciseId(ctx context.Context, userId, exerciseId int) ([]*Workout, error) {
+	return w.queryWorkouts(ctx, selectAllWorkQuery, userId, exerciseId)
+}
+
+func (w WorkoutModel) GetByUserId(ctx context.Context, userId int) ([]*Workout, error) {
+	return w.queryWorkouts(ctx, selectWorkoutByUserId, userId)
+}
+
+// GetAll returns a page of the user's workouts, optionally restricted to one
+// exercise (exerciseId > 0), together with the paging metadata.
+func (w WorkoutModel) GetAll(ctx context.Context, userId, exerciseId int, f filters.Filters) ([]*Workout, filters.Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeouts.Read)
 	defer cancel()
 
-	args := []interface{}{userId, exerciseId}
+	query := fmt.Sprintf(selectWorkoutsPageQuery, f.SortColumn(), f.SortDirection())
+	args := []interface{}{userId, exerciseId, f.FromArg(), f.ToArg(), f.Limit(), f.Offset()}
 
-	rows, err := w.db.QueryContext(ctx, selectAllWorkQuery, args...)
+	rows, err := w.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		fmt.Println(err.Error())
-		return nil, err
+		return nil, filters.Metadata{}, err
 	}
-	var workouts []*Workout
+	defer rows.Close()
+
+	totalRecords := 0
+	workouts := []*Workout{}
 
 	for rows.Next() {
 		var workout Workout
-		var reps64 []int64
-		var weights64 []int64
-
-		scanErr := rows.Scan(&workout.WorkoutId,
-			&workout.ExerciseId,
-			&workout.UserId,
-			&workout.Duration,
-			&workout.Sets,
-			pq.Array(&reps64),
-			pq.Array(&weights64),
-			&workout.CreatedAt)
-		if scanErr != nil {
-			fmt.Println(scanErr)
-			fmt.Println("error occurred while scanning rows in workout")
-			return nil, err
+		err = scanWorkout(rows, &workout, &totalRecords)
+		if err != nil {
+			return nil, filters.Metadata{}, err
 		}
+		workouts = append(workouts, &workout)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, filters.Metadata{}, err
+	}
 
-		for i := range weights64 {
-			workout.Weights = append(workout.Weights, int(weights64[i]))
-			workout.Reps = append(workout.Reps, int(reps64[i]))
-		}
+	return workouts, filters.CalculateMetadata(totalRecords, f.Page, f.PageSize), nil
+}
+
+// GetAllAfter returns up to f.PageSize workouts that come after the cursor in
+// (created_at, workout_id) order, and the cursor of the next page, which is nil
+// on the last page. Unlike offset paging, rows inserted while a client walks
+// the pages never shift or duplicate entries.
+func (w WorkoutModel) GetAllAfter(ctx context.Context, userId, exerciseId int, f filters.Filters, after filters.Cursor) ([]*Workout, *filters.Cursor, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeouts.Read)
+	defer cancel()
+
+	comparison, direction := ">", "ASC"
+	if after.Descending {
+		comparison, direction = "<", "DESC"
+	}
+	query := fmt.Sprintf(selectWorkoutsKeysetQuery, comparison, direction, direction)
 
+	afterTime, afterId := after.CursorArgs()
+	// one extra row tells whether there is a next page
+	args := []interface{}{userId, exerciseId, afterTime, afterId, f.FromArg(), f.ToArg(), f.PageSize + 1}
+
+	rows, err := w.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	workouts := []*Workout{}
+	for rows.Next() {
+		var workout Workout
+		err = scanWorkout(rows, &workout)
+		if err != nil {
+			return nil, nil, err
+		}
 		workouts = append(workouts, &workout)
 	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(workouts) <= f.PageSize {
+		return workouts, nil, nil
+	}
 
-	return workouts, nil
+	workouts = workouts[:f.PageSize]
+	last := workouts[len(workouts)-1]
+	next := filters.Cursor{CreatedAt: last.CreatedAt, Id: last.WorkoutId, Descending: after.Descending}
+	return workouts, &next, nil
 }
 
-func (w WorkoutModel) GetByUserId(userId int) ([]*Workout, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+func (w WorkoutModel) queryWorkouts(ctx context.Context, query string, args ...interface{}) ([]*Workout, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeouts.Read)
 	defer cancel()
 
-	args := []interface{}{userId}
-
-	rows, err := w.db.QueryContext(ctx, selectWorkoutByUserId, args...)
+	rows, err := w.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		fmt.Println(err.Error())
+		w.logger.PrintDebug("querying workouts failed", map[string]string{"error": err.Error()})
 		return nil, err
 	}
+	defer rows.Close()
+
 	var workouts []*Workout
 
 	for rows.Next() {
 		var workout Workout
-		var reps64 []int64
-		var weights64 []int64
-
-		scanErr := rows.Scan(&workout.WorkoutId,
-			&workout.ExerciseId,
-			&workout.UserId,
-			&workout.Duration,
-			&workout.Sets,
-			pq.Array(&reps64),
-			pq.Array(&weights64),
-			&workout.CreatedAt)
-		if scanErr != nil {
-			fmt.Println(scanErr)
-			fmt.Println("error occurred while scanning rows in workout")
+		err = scanWorkout(rows, &workout)
+		if err != nil {
+			w.logger.PrintDebug("scanning workout failed", map[string]string{"error": err.Error()})
 			return nil, err
 		}
+		workouts = append(workouts, &workout)
+	}
 
-		for i := range weights64 {
-			workout.Weights = append(workout.Weights, int(weights64[i]))
-			workout.Reps = append(workout.Reps, int(reps64[i]))
-		}
+	return workouts, rows.Err()
+}
 
-		workouts = append(workouts, &workout)
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanWorkout scans the columns of selectWorkoutColumns into workout, after any
+// leading destinations such as a window count.
+func scanWorkout(row rowScanner, workout *Workout, leading ...interface{}) error {
+	var reps64 []int64
+	var weights64 []int64
+	var rpe sql.NullFloat64
+
+	dest := append(leading,
+		&workout.WorkoutId,
+		&workout.ExerciseId,
+		&workout.UserId,
+		&workout.Duration,
+		&workout.Sets,
+		pq.Array(&reps64),
+		pq.Array(&weights64),
+		&workout.CreatedAt,
+		&rpe,
+		&workout.Version)
+	err := row.Scan(dest...)
+	if err != nil {
+		return err
 	}
 
-	return workouts, nil
+	for i := range weights64 {
+		workout.Weights = append(workout.Weights, int(weights64[i]))
+		workout.Reps = append(workout.Reps, int(reps64[i]))
+	}
+	workout.Rpe = rpe.Float64
+	return nil
 }
 
 func ValidateWorkout(v *validator.Validator, workout *Workout) bool {
@@ -262,5 +411,11 @@ func ValidateWorkout(v *validator.Validator, workout *Workout) bool {
 	v.Check(workout.ExerciseId > 0, "exercise id", "should be > 0")
 	v.Check(workout.Duration > 0, "duration of workout", "should be > 0")
 	v.Check(len(workout.Weights) == len(workout.Reps), "number of weights", "number of weights == number of reps")
+	v.Check(workout.Rpe == 0 || (workout.Rpe >= 1 && workout.Rpe <= 10), "rpe", "should be between 1 and 10")
 	return v.Valid()
 }
+
+// nullRpe stores an unrated workout (rpe 0) as NULL.
+func nullRpe(rpe float64) sql.NullFloat64 {
+	return sql.NullFloat64{Float64: rpe, Valid: rpe > 0}
+}
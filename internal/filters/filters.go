@@ -0,0 +1,154 @@
+// Package filters holds the paging, sorting and date range options shared by
+// every list endpoint, and the metadata returned alongside a page.
+package filters
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"time"
+	"workout-microservice/internal/validator"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+	MaxPage         = 10_000_000
+)
+
+// Filters is the parsed paging, sorting and date range of a list request.
+// A zero PageSize disables paging, which is what internal callers that need
+// the whole history use.
+type Filters struct {
+	Page         int
+	PageSize     int
+	Sort         string
+	SortSafelist []string
+	From         time.Time
+	To           time.Time
+}
+
+type Metadata struct {
+	CurrentPage  int `json:"current_page,omitempty"`
+	PageSize     int `json:"page_size,omitempty"`
+	FirstPage    int `json:"first_page,omitempty"`
+	LastPage     int `json:"last_page,omitempty"`
+	TotalRecords int `json:"total_records"`
+}
+
+func Validate(v *validator.Validator, f Filters) bool {
+	v.Check(f.Page > 0, "page", "must be greater than zero")
+	v.Check(f.Page <= MaxPage, "page", "must be a maximum of 10 million")
+	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
+	v.Check(f.PageSize <= MaxPageSize, "page_size", "must be a maximum of 100")
+	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	v.Check(f.From.IsZero() || f.To.IsZero() || f.From.Before(f.To), "from", "must be before to")
+	return v.Valid()
+}
+
+// SortColumn returns the column to order by. The sort value is only ever
+// interpolated into SQL after being checked against the safelist here, an
+// empty sort falls back to the first entry of the safelist.
+func (f Filters) SortColumn() string {
+	sort := f.Sort
+	if sort == "" && len(f.SortSafelist) > 0 {
+		sort = f.SortSafelist[0]
+	}
+	for _, safeValue := range f.SortSafelist {
+		if sort == safeValue {
+			return strings.TrimPrefix(sort, "-")
+		}
+	}
+	panic("unsafe sort parameter: " + f.Sort)
+}
+
+func (f Filters) SortDirection() string {
+	sort := f.Sort
+	if sort == "" && len(f.SortSafelist) > 0 {
+		sort = f.SortSafelist[0]
+	}
+	if strings.HasPrefix(sort, "-") {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// Limit returns the LIMIT argument, NULL (no limit) when paging is disabled.
+func (f Filters) Limit() sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(f.PageSize), Valid: f.PageSize > 0}
+}
+
+func (f Filters) Offset() int {
+	if f.PageSize <= 0 || f.Page <= 0 {
+		return 0
+	}
+	return (f.Page - 1) * f.PageSize
+}
+
+// FromArg and ToArg return the date range bounds as nullable query arguments.
+func (f Filters) FromArg() sql.NullTime {
+	return sql.NullTime{Time: f.From, Valid: !f.From.IsZero()}
+}
+
+func (f Filters) ToArg() sql.NullTime {
+	return sql.NullTime{Time: f.To, Valid: !f.To.IsZero()}
+}
+
+func CalculateMetadata(totalRecords, page, pageSize int) Metadata {
+	if totalRecords == 0 || pageSize <= 0 {
+		return Metadata{TotalRecords: totalRecords}
+	}
+
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		TotalRecords: totalRecords,
+	}
+}
+
+// Cursor marks the position of the last row of a keyset page. It is handed to
+// clients as an opaque string and only ever compared against the
+// (created_at, id) ordering it was produced for.
+type Cursor struct {
+	CreatedAt  time.Time `json:"t"`
+	Id         int       `json:"id"`
+	Descending bool      `json:"desc"`
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+func (c Cursor) Encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// DecodeCursor parses a cursor produced by Encode.
+func DecodeCursor(s string) (Cursor, error) {
+	var c Cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	err = json.Unmarshal(js, &c)
+	if err != nil || c.CreatedAt.IsZero() || c.Id < 1 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// IsZero reports whether c is the start of the listing rather than a position in it.
+func (c Cursor) IsZero() bool {
+	return c.CreatedAt.IsZero() && c.Id == 0
+}
+
+// CursorArgs returns the cursor position as nullable query arguments, NULL
+// meaning "from the beginning".
+func (c Cursor) CursorArgs() (sql.NullTime, int) {
+	return sql.NullTime{Time: c.CreatedAt, Valid: !c.IsZero()}, c.Id
+}
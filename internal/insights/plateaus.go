@@ -0,0 +1,226 @@
+// Package insights analyses a user's workout history to point out exercises
+// that need attention, such as plateaus.
+package insights
+
+import (
+	"math"
+	"slices"
+	"time"
+	"workout-microservice/internal/data"
+	"workout-microservice/internal/training"
+)
+
+const (
+	StatusStalled    = "stalled"
+	StatusRegressing = "regressing"
+)
+
+const (
+	SuggestDeload         = "deload"
+	SuggestRepRangeChange = "rep_range_change"
+	SuggestVariationSwap  = "variation_swap"
+)
+
+// PlateauOptions tunes when an exercise counts as stalled.
+type PlateauOptions struct {
+	// Sessions is the number of most recent sessions that must not beat the
+	// best e1RM before them.
+	Sessions int
+	// Weeks is the time without a new best e1RM after which the exercise is
+	// stalled regardless of the number of sessions.
+	Weeks int
+	// Regression is the relative drop of the recent best e1RM, below the all
+	// time best, from which the exercise counts as regressing.
+	Regression float64
+}
+
+var DefaultPlateauOptions = PlateauOptions{Sessions: 6, Weeks: 4, Regression: 0.05}
+
+type Suggestion struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+type Plateau struct {
+	ExerciseId            int          `json:"exercise_id"`
+	Status                string       `json:"status"`
+	SessionsAnalysed      int          `json:"sessions_analysed"`
+	BestE1rm              float64      `json:"best_e1rm"`
+	RecentBestE1rm        float64      `json:"recent_best_e1rm"`
+	LastImprovement       time.Time    `json:"last_improvement"`
+	WeeksSinceImprovement int          `json:"weeks_since_improvement"`
+	TrendPerSession       float64      `json:"trend_per_session"`
+	Suggestions           []Suggestion `json:"suggestions"`
+}
+
+type session struct {
+	at   time.Time
+	e1rm float64
+	reps []int
+}
+
+func toSessions(workouts []*data.Workout) []session {
+	sessions := make([]session, 0, len(workouts))
+	for _, w := range workouts {
+		best := 0.0
+		for i := range w.Weights {
+			if i < len(w.Reps) {
+				best = math.Max(best, training.E1RM(w.Weights[i], w.Reps[i]))
+			}
+		}
+		if best > 0 {
+			sessions = append(sessions, session{at: w.CreatedAt, e1rm: best, reps: w.Reps})
+		}
+	}
+	slices.SortFunc(sessions, func(a, b session) int { return a.at.Compare(b.at) })
+	return sessions
+}
+
+// DetectPlateaus groups the workouts by exercise and returns the exercises that
+// stalled or regressed, most recently improved last.
+func DetectPlateaus(workouts []*data.Workout, opts PlateauOptions, now time.Time) []Plateau {
+	byExercise := make(map[int][]*data.Workout)
+	for _, w := range workouts {
+		byExercise[w.ExerciseId] = append(byExercise[w.ExerciseId], w)
+	}
+
+	plateaus := []Plateau{}
+	for exerciseId, history := range byExercise {
+		if p, ok := DetectPlateau(exerciseId, history, opts, now); ok {
+			plateaus = append(plateaus, p)
+		}
+	}
+	slices.SortFunc(plateaus, func(a, b Plateau) int {
+		if c := a.LastImprovement.Compare(b.LastImprovement); c != 0 {
+			return c
+		}
+		return a.ExerciseId - b.ExerciseId
+	})
+	return plateaus
+}
+
+// DetectPlateau analyses the history of a single exercise. It reports false
+// when the exercise is still progressing or there is not enough history.
+func DetectPlateau(exerciseId int, history []*data.Workout, opts PlateauOptions, now time.Time) (Plateau, bool) {
+	sessions := toSessions(history)
+	if opts.Sessions < 2 || len(sessions) <= opts.Sessions {
+		return Plateau{}, false
+	}
+
+	recent := sessions[len(sessions)-opts.Sessions:]
+	p := Plateau{ExerciseId: exerciseId, SessionsAnalysed: len(sessions)}
+
+	for _, s := range sessions {
+		if s.e1rm > p.BestE1rm {
+			p.BestE1rm = s.e1rm
+			p.LastImprovement = s.at
+		}
+	}
+	for _, s := range recent {
+		p.RecentBestE1rm = math.Max(p.RecentBestE1rm, s.e1rm)
+	}
+	p.WeeksSinceImprovement = int(now.Sub(p.LastImprovement).Hours() / (24 * 7))
+	p.TrendPerSession = round(slope(recent))
+
+	stalledSessions := p.LastImprovement.Before(recent[0].at)
+	stalledWeeks := opts.Weeks > 0 && p.WeeksSinceImprovement >= opts.Weeks
+	regressing := p.RecentBestE1rm < p.BestE1rm*(1-opts.Regression) && p.TrendPerSession < 0
+
+	switch {
+	case regressing:
+		p.Status = StatusRegressing
+	case stalledSessions || stalledWeeks:
+		p.Status = StatusStalled
+	default:
+		return Plateau{}, false
+	}
+
+	p.BestE1rm = round(p.BestE1rm)
+	p.RecentBestE1rm = round(p.RecentBestE1rm)
+	p.Suggestions = suggest(p, recent, opts)
+	return p, true
+}
+
+func suggest(p Plateau, recent []session, opts PlateauOptions) []Suggestion {
+	var suggestions []Suggestion
+
+	if p.Status == StatusRegressing || p.TrendPerSession < 0 {
+		suggestions = append(suggestions, Suggestion{
+			Type:   SuggestDeload,
+			Reason: "estimated 1RM is trending down, take a lighter week at around 60% of your usual load to recover",
+		})
+	}
+
+	switch repRange(recent) {
+	case "low":
+		suggestions = append(suggestions, Suggestion{
+			Type:   SuggestRepRangeChange,
+			Reason: "recent sessions were mostly sets of 1-5, spend a block on sets of 6-10 to build volume",
+		})
+	case "high":
+		suggestions = append(suggestions, Suggestion{
+			Type:   SuggestRepRangeChange,
+			Reason: "recent sessions were mostly sets above 10, spend a block on heavier sets of 3-6",
+		})
+	default:
+		suggestions = append(suggestions, Suggestion{
+			Type:   SuggestRepRangeChange,
+			Reason: "recent sessions were mostly sets of 6-10, alternate with a heavier block of 3-5",
+		})
+	}
+
+	if opts.Weeks > 0 && p.WeeksSinceImprovement >= 2*opts.Weeks {
+		suggestions = append(suggestions, Suggestion{
+			Type:   SuggestVariationSwap,
+			Reason: "no improvement for a long time, swap to a close variation (pause, tempo or grip change) for a few weeks",
+		})
+	}
+	return suggestions
+}
+
+// repRange returns the range most of the recent sets fall in.
+func repRange(sessions []session) string {
+	counts := map[string]int{}
+	for _, s := range sessions {
+		for _, reps := range s.reps {
+			switch {
+			case reps <= 5:
+				counts["low"]++
+			case reps <= 10:
+				counts["mid"]++
+			default:
+				counts["high"]++
+			}
+		}
+	}
+
+	dominant := "mid"
+	for _, r := range []string{"low", "high"} {
+		if counts[r] > counts[dominant] {
+			dominant = r
+		}
+	}
+	return dominant
+}
+
+// slope is the least squares e1RM change per session.
+func slope(sessions []session) float64 {
+	n := float64(len(sessions))
+	if n < 2 {
+		return 0
+	}
+
+	var sumX, sumY, sumXY, sumXX float64
+	for i, s := range sessions {
+		x := float64(i)
+		sumX += x
+		sumY += s.e1rm
+		sumXY += x * s.e1rm
+		sumXX += x * x
+	}
+	return (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+}
+
+func round(f float64) float64 {
+	return math.Round(f*100) / 100
+}
@@ -0,0 +1,179 @@
+// Package jsonlog writes leveled log entries as JSON objects or as plain text
+// lines, one entry per line.
+package jsonlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int8
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelError
+	LevelFatal
+	LevelOff
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelError:
+		return "ERROR"
+	case LevelFatal:
+		return "FATAL"
+	case LevelOff:
+		return "OFF"
+	default:
+		return ""
+	}
+}
+
+// ParseLevel parses the -log-level flag values debug, info, error, fatal and off.
+func ParseLevel(s string) (Level, error) {
+	for l := LevelDebug; l <= LevelOff; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, use debug, info, error, fatal or off", s)
+}
+
+type Format int8
+
+const (
+	FormatJSON Format = iota
+	FormatText
+)
+
+// ParseFormat parses the -log-format flag values json and text.
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "json":
+		return FormatJSON, nil
+	case "text":
+		return FormatText, nil
+	}
+	return 0, fmt.Errorf("unknown log format %q, use json or text", s)
+}
+
+// Logger writes entries at or above its minimum level to out. It is safe for
+// concurrent use.
+type Logger struct {
+	out      io.Writer
+	minLevel Level
+	format   Format
+	mu       sync.Mutex
+}
+
+func New(out io.Writer, minLevel Level, format Format) *Logger {
+	return &Logger{out: out, minLevel: minLevel, format: format}
+}
+
+func (l *Logger) PrintDebug(message string, properties map[string]string) {
+	l.print(LevelDebug, message, properties)
+}
+
+func (l *Logger) PrintInfo(message string, properties map[string]string) {
+	l.print(LevelInfo, message, properties)
+}
+
+func (l *Logger) PrintError(err error, properties map[string]string) {
+	l.print(LevelError, err.Error(), properties)
+}
+
+// PrintFatal logs err and exits with status 1.
+func (l *Logger) PrintFatal(err error, properties map[string]string) {
+	l.print(LevelFatal, err.Error(), properties)
+	os.Exit(1)
+}
+
+// Enabled reports whether entries at level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.minLevel && l.minLevel < LevelOff
+}
+
+func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
+	if !l.Enabled(level) {
+		return 0, nil
+	}
+
+	aux := struct {
+		Level      string            `json:"level"`
+		Time       string            `json:"time"`
+		Message    string            `json:"message"`
+		Properties map[string]string `json:"properties,omitempty"`
+		Trace      string            `json:"trace,omitempty"`
+	}{
+		Level:      level.String(),
+		Time:       time.Now().UTC().Format(time.RFC3339),
+		Message:    message,
+		Properties: properties,
+	}
+
+	// errors carry the stack of the goroutine that logged them
+	if level >= LevelError {
+		aux.Trace = string(debug.Stack())
+	}
+
+	var line []byte
+	switch l.format {
+	case FormatText:
+		line = textLine(aux.Time, aux.Level, aux.Message, aux.Properties, aux.Trace)
+	default:
+		var err error
+		line, err = json.Marshal(aux)
+		if err != nil {
+			line = []byte(LevelError.String() + ": unable to marshal log message: " + err.Error())
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.out.Write(append(line, '\n'))
+}
+
+// textLine formats an entry as "time LEVEL message key=value ...", with the
+// properties sorted by key and the trace on the lines that follow.
+func textLine(t, level, message string, properties map[string]string, trace string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-5s %s", t, level, message)
+
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := properties[key]
+		if value == "" || strings.ContainsAny(value, " \t\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, " %s=%s", key, value)
+	}
+
+	if trace != "" {
+		b.WriteString("\n")
+		b.WriteString(strings.TrimRight(trace, "\n"))
+	}
+	return []byte(b.String())
+}
+
+// Write logs p as an error entry, so the Logger can back the log.Logger of an
+// http.Server.
+func (l *Logger) Write(p []byte) (n int, err error) {
+	return l.print(LevelError, strings.TrimRight(string(p), "\n"), nil)
+}
@@ -0,0 +1,359 @@
+// Package migrate applies the numbered SQL migrations of the migrations
+// package to a database and tracks the applied version in schema_migrations.
+// The table has the same layout golang-migrate uses, so databases migrated
+// with its CLI are picked up where they left off.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+const createVersionTableQuery = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version bigint NOT NULL PRIMARY KEY,
+    dirty boolean NOT NULL
+);`
+
+// the version table queries only look for schema_migrations, so that reading
+// the version of a database never writes to it.
+const versionTableExistsQuery = `SELECT to_regclass('schema_migrations') IS NOT NULL;`
+
+const versionTableExistsSQLiteQuery = `SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations');`
+
+const selectVersionQuery = `SELECT version, dirty FROM schema_migrations LIMIT 1;`
+
+const deleteVersionQuery = `DELETE FROM schema_migrations;`
+
+const insertVersionQuery = `INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2);`
+
+// every migrating process takes this advisory lock, so that replicas started
+// together with -auto-migrate apply each migration exactly once.
+const lockQuery = `SELECT pg_advisory_lock($1);`
+
+const unlockQuery = `SELECT pg_advisory_unlock($1);`
+
+const lockId = 7_351_042_019
+
+var fileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+var (
+	// ErrDirty is returned when a migration was left half applied, which can
+	// only be fixed by hand.
+	ErrDirty = errors.New("database is in a dirty migration state, fix the schema by hand and reset schema_migrations")
+	// ErrUnknownVersion is returned by Goto for a version with no migration.
+	ErrUnknownVersion = errors.New("no migration with that version")
+)
+
+// Migration is one numbered schema change with the SQL to apply and revert it.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%06d_%s", m.Version, m.Name)
+}
+
+// Status reports whether a migration is applied to the database.
+type Status struct {
+	Migration
+	Applied bool
+}
+
+// Load reads the migrations in the root of fsys, every version must have both
+// an up and a down file.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s: invalid version", entry.Name())
+		}
+		contents, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %s must have both an up and a down file", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	// advisoryLock is false on SQLite, which has no advisory locks. SQLite
+	// databases are used by a single server, so there are no replicas to race.
+	advisoryLock       bool
+	versionTableExists string
+}
+
+// New returns a Migrator for the migrations in fsys on a Postgres database.
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations, advisoryLock: true, versionTableExists: versionTableExistsQuery}, nil
+}
+
+// NewSQLite returns a Migrator for the migrations in fsys on a SQLite database.
+func NewSQLite(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations, versionTableExists: versionTableExistsSQLiteQuery}, nil
+}
+
+// Latest returns the version of the newest migration, 0 when there are none.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the version the database is at, 0 for an empty database. It
+// only reads, so it is cheap enough for the readiness probe.
+func (m *Migrator) Version(ctx context.Context) (int64, bool, error) {
+	var exists bool
+	err := m.db.QueryRowContext(ctx, m.versionTableExists).Scan(&exists)
+	if err != nil || !exists {
+		return 0, false, err
+	}
+	return readVersion(ctx, m.db)
+}
+
+// Status lists every migration and whether it is applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, int64, bool, error) {
+	version, dirty, err := m.Version(ctx)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		statuses = append(statuses, Status{Migration: migration, Applied: migration.Version <= version})
+	}
+	return statuses, version, dirty, nil
+}
+
+// Up applies every pending migration and returns the ones it applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	return m.migrate(ctx, func(int64) (int64, error) {
+		return m.Latest(), nil
+	})
+}
+
+// Down reverts the last steps applied migrations and returns the ones it reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	return m.migrate(ctx, func(current int64) (int64, error) {
+		i := len(m.migrations) - 1
+		for i >= 0 && m.migrations[i].Version > current {
+			i--
+		}
+		i -= steps
+		if i < 0 {
+			return 0, nil
+		}
+		return m.migrations[i].Version, nil
+	})
+}
+
+// Goto migrates up or down to version, 0 reverting every migration.
+func (m *Migrator) Goto(ctx context.Context, version int64) ([]Migration, error) {
+	if version != 0 && m.find(version) < 0 {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	return m.migrate(ctx, func(int64) (int64, error) {
+		return version, nil
+	})
+}
+
+func (m *Migrator) find(version int64) int {
+	for i, migration := range m.migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// migrate moves the database to the version picked by target while holding
+// the migration lock. It refuses to run on a dirty database. Each migration
+// runs in its own transaction together with the version bump, see apply.
+func (m *Migrator) migrate(ctx context.Context, target func(current int64) (int64, error)) ([]Migration, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if m.advisoryLock {
+		_, err = conn.ExecContext(ctx, lockQuery, lockId)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.ExecContext(context.Background(), unlockQuery, lockId)
+	}
+
+	_, err = conn.ExecContext(ctx, createVersionTableQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	current, dirty, err := readVersion(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if dirty {
+		return nil, ErrDirty
+	}
+
+	to, err := target(current)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	if to > current {
+		for _, migration := range m.migrations {
+			if migration.Version <= current || migration.Version > to {
+				continue
+			}
+			err = apply(ctx, conn, migration.Up, migration.Version)
+			if err != nil {
+				return done, fmt.Errorf("migration %s up: %w", migration, err)
+			}
+			done = append(done, migration)
+		}
+		return done, nil
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if migration.Version > current || migration.Version <= to {
+			continue
+		}
+		var previous int64
+		if i > 0 {
+			previous = m.migrations[i-1].Version
+		}
+		err = apply(ctx, conn, migration.Down, previous)
+		if err != nil {
+			return done, fmt.Errorf("migration %s down: %w", migration, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func readVersion(ctx context.Context, q queryer) (int64, bool, error) {
+	var version int64
+	var dirty bool
+
+	err := q.QueryRowContext(ctx, selectVersionQuery).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// apply runs the SQL of a migration and records version as the new schema
+// version, 0 leaving schema_migrations empty. The target version is marked
+// dirty before the migration runs and only cleared in the transaction of the
+// migration, so a migration that fails leaves the database dirty until it has
+// been checked by hand, like golang-migrate does.
+func apply(ctx context.Context, conn *sql.Conn, query string, version int64) error {
+	err := setVersion(ctx, conn, version, true)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, deleteVersionQuery)
+	if err != nil {
+		return err
+	}
+	if version > 0 {
+		_, err = tx.ExecContext(ctx, insertVersionQuery, version, false)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// setVersion replaces the recorded schema version in its own transaction.
+func setVersion(ctx context.Context, conn *sql.Conn, version int64, dirty bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, deleteVersionQuery)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, insertVersionQuery, version, dirty)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,260 @@
+// Package plates works out how to load a barbell from a limited set of plates
+// and builds warm-up ladders that can actually be loaded with them.
+package plates
+
+import (
+	"errors"
+	"math"
+	"slices"
+)
+
+const (
+	UnitKg = "kg"
+	UnitLb = "lb"
+)
+
+// weights are handled in hundredths internally so that fractional plates such
+// as 1.25kg or 2.5lb add up exactly.
+const scale = 100
+
+// MaxPlate is the heaviest plate accepted in an inventory. Together with the
+// 0.01 resolution it bounds the work Calculate does for any inventory.
+const MaxPlate = 100
+
+var (
+	ErrUnknownUnit  = errors.New("unit must be kg or lb")
+	ErrBelowBar     = errors.New("target weight is lighter than the bar")
+	ErrNoInventory  = errors.New("plate inventory is empty")
+	ErrInvalidPlate = errors.New("plates must weigh between 0.01 and 100")
+)
+
+// DefaultBar returns the standard barbell weight for the unit.
+func DefaultBar(unit string) (float64, error) {
+	switch unit {
+	case UnitKg:
+		return 20, nil
+	case UnitLb:
+		return 45, nil
+	default:
+		return 0, ErrUnknownUnit
+	}
+}
+
+// DefaultInventory returns a typical commercial gym plate inventory, where
+// every entry is one pair of plates.
+func DefaultInventory(unit string) ([]float64, error) {
+	switch unit {
+	case UnitKg:
+		return []float64{25, 25, 25, 25, 20, 20, 15, 10, 5, 5, 2.5, 1.25}, nil
+	case UnitLb:
+		return []float64{45, 45, 45, 45, 45, 45, 35, 25, 10, 10, 5, 2.5}, nil
+	default:
+		return nil, ErrUnknownUnit
+	}
+}
+
+// Loadout describes the plates to put on each side of the bar.
+type Loadout struct {
+	Target    float64   `json:"target"`
+	Achieved  float64   `json:"achieved"`
+	Bar       float64   `json:"bar"`
+	PerSide   []float64 `json:"per_side"`
+	Remainder float64   `json:"remainder"`
+	Exact     bool      `json:"exact"`
+}
+
+type denomination struct {
+	weight int
+	pairs  int
+}
+
+func toScaled(f float64) int {
+	return int(math.Round(f * scale))
+}
+
+func fromScaled(i int) float64 {
+	return float64(i) / scale
+}
+
+// ValidPlate reports whether plate can be used in an inventory: it has to be
+// at least 0.01, the resolution weights are handled at, and at most MaxPlate.
+func ValidPlate(plate float64) bool {
+	return toScaled(plate) >= 1 && plate <= MaxPlate
+}
+
+func denominations(inventory []float64) ([]denomination, error) {
+	if len(inventory) == 0 {
+		return nil, ErrNoInventory
+	}
+
+	counts := make(map[int]int)
+	for _, plate := range inventory {
+		if !ValidPlate(plate) {
+			return nil, ErrInvalidPlate
+		}
+		counts[toScaled(plate)]++
+	}
+
+	denoms := make([]denomination, 0, len(counts))
+	for weight, pairs := range counts {
+		denoms = append(denoms, denomination{weight: weight, pairs: pairs})
+	}
+	slices.SortFunc(denoms, func(a, b denomination) int { return b.weight - a.weight })
+	return denoms, nil
+}
+
+// search finds the heaviest loadable side weight not above target, preferring
+// fewer plates among equally heavy solutions, and returns its plates heaviest
+// first.
+//
+// It is a subset-sum over the side weights, each denomination split into
+// items of 1, 2, 4... pairs so that no more pairs are used than there are.
+// Weights are divided by their greatest common divisor and the target is
+// capped at the whole inventory, so the table never grows beyond 50 plates of
+// MaxPlate at 0.01 resolution whatever the target.
+func search(denoms []denomination, target int) []int {
+	type item struct {
+		weight, plates, denom int
+	}
+
+	step, total := 0, 0
+	var items []item
+	for i, d := range denoms {
+		step = gcd(step, d.weight)
+		total += d.weight * d.pairs
+		for k := 1; d.pairs > 0; k *= 2 {
+			k = min(k, d.pairs)
+			items = append(items, item{weight: k * d.weight, plates: k, denom: i})
+			d.pairs -= k
+		}
+	}
+	size := min(target, total) / step
+
+	// plates[w] is the fewest plates adding up to w*step, -1 when none do.
+	// Among as many plates, the loadout with the larger sum of squared plate
+	// weights wins, which favours the heavier plates like loading by hand does.
+	plates := make([]int, size+1)
+	squares := make([]int, size+1)
+	for w := range plates {
+		plates[w] = -1
+	}
+	plates[0] = 0
+	taken := make([][]bool, len(items))
+	for i, it := range items {
+		taken[i] = make([]bool, size+1)
+		weight := it.weight / step
+		itemSquares := it.plates * denoms[it.denom].weight * denoms[it.denom].weight
+		for w := size; w >= weight; w-- {
+			from := plates[w-weight]
+			if from < 0 {
+				continue
+			}
+			count, sq := from+it.plates, squares[w-weight]+itemSquares
+			if plates[w] < 0 || count < plates[w] || (count == plates[w] && sq > squares[w]) {
+				plates[w], squares[w] = count, sq
+				taken[i][w] = true
+			}
+		}
+	}
+
+	best := size
+	for plates[best] < 0 {
+		best--
+	}
+
+	var loaded []int
+	for i := len(items) - 1; i >= 0; i-- {
+		if !taken[i][best] {
+			continue
+		}
+		for k := 0; k < items[i].plates; k++ {
+			loaded = append(loaded, denoms[items[i].denom].weight)
+		}
+		best -= items[i].weight / step
+	}
+	slices.SortFunc(loaded, func(a, b int) int { return b - a })
+	return loaded
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// Calculate returns the plates to load per side so that the bar is as close to
+// target as possible without going over it.
+func Calculate(target, bar float64, inventory []float64) (Loadout, error) {
+	if target < bar {
+		return Loadout{}, ErrBelowBar
+	}
+
+	denoms, err := denominations(inventory)
+	if err != nil {
+		return Loadout{}, err
+	}
+
+	side := (toScaled(target) - toScaled(bar)) / 2
+	plates := search(denoms, side)
+
+	loadout := Loadout{
+		Target:  target,
+		Bar:     bar,
+		PerSide: make([]float64, 0, len(plates)),
+	}
+	loaded := 0
+	for _, p := range plates {
+		loaded += p
+		loadout.PerSide = append(loadout.PerSide, fromScaled(p))
+	}
+	loadout.Achieved = fromScaled(toScaled(bar) + 2*loaded)
+	loadout.Remainder = fromScaled(toScaled(target) - toScaled(loadout.Achieved))
+	loadout.Exact = loadout.Remainder == 0
+	return loadout, nil
+}
+
+// WarmUpSet is a single step of a warm-up ladder.
+type WarmUpSet struct {
+	Percent float64 `json:"percent"`
+	Reps    int     `json:"reps"`
+	Loadout Loadout `json:"loadout"`
+}
+
+var warmUpLadder = []struct {
+	percent float64
+	reps    int
+}{
+	{percent: 0, reps: 10},
+	{percent: 40, reps: 5},
+	{percent: 60, reps: 3},
+	{percent: 75, reps: 2},
+	{percent: 85, reps: 1},
+	{percent: 92, reps: 1},
+}
+
+// WarmUp builds a warm-up ladder towards target starting with the empty bar.
+// Every step is rounded down to a loadable weight and steps that would repeat
+// the previous weight are dropped, so light targets get shorter ladders.
+func WarmUp(target, bar float64, inventory []float64) ([]WarmUpSet, error) {
+	if target < bar {
+		return nil, ErrBelowBar
+	}
+
+	sets := make([]WarmUpSet, 0, len(warmUpLadder))
+	previous := -1.0
+	for _, step := range warmUpLadder {
+		weight := math.Max(bar, math.Round(target*step.percent)/100)
+		loadout, err := Calculate(weight, bar, inventory)
+		if err != nil {
+			return nil, err
+		}
+		if loadout.Achieved <= previous || loadout.Achieved >= target {
+			continue
+		}
+		previous = loadout.Achieved
+
+		sets = append(sets, WarmUpSet{Percent: step.percent, Reps: step.reps, Loadout: loadout})
+	}
+	return sets, nil
+}
@@ -0,0 +1,151 @@
+package plates
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCalculate(t *testing.T) {
+	kg, _ := DefaultInventory(UnitKg)
+
+	// every subset of these 30 distinct plates is a multiple of 1.02, so a
+	// target just above one makes the old backtracking search try every
+	// combination
+	var distinct []float64
+	for i := 1; i <= 30; i++ {
+		distinct = append(distinct, float64(i)*1.02)
+	}
+
+	tests := []struct {
+		name      string
+		target    float64
+		bar       float64
+		inventory []float64
+		perSide   []float64
+		achieved  float64
+		err       error
+	}{
+		{
+			name:      "exact loadout from the gym defaults",
+			target:    142.5,
+			bar:       20,
+			inventory: kg,
+			perSide:   []float64{25, 25, 10, 1.25},
+			achieved:  142.5,
+		},
+		{
+			name:      "rounds down when the target cannot be loaded",
+			target:    101,
+			bar:       20,
+			inventory: kg,
+			perSide:   []float64{25, 15},
+			achieved:  100,
+		},
+		{
+			name:      "prefers fewer plates among equally heavy loadouts",
+			target:    60,
+			bar:       20,
+			inventory: []float64{20, 10, 10, 5, 5, 5, 5},
+			perSide:   []float64{20},
+			achieved:  60,
+		},
+		{
+			name:      "only uses as many pairs as there are",
+			target:    100,
+			bar:       20,
+			inventory: []float64{10, 10, 5},
+			perSide:   []float64{10, 10, 5},
+			achieved:  70,
+		},
+		{
+			name:      "the empty bar when no plate fits",
+			target:    22,
+			bar:       20,
+			inventory: []float64{5},
+			perSide:   []float64{},
+			achieved:  20,
+		},
+		{
+			name:      "a heavy target is capped at the whole inventory",
+			target:    1e9,
+			bar:       20,
+			inventory: []float64{25, 10},
+			perSide:   []float64{25, 10},
+			achieved:  90,
+		},
+		{
+			name:      "many distinct plates and an unreachable target",
+			target:    20 + 2*(465.12+1),
+			bar:       20,
+			inventory: distinct,
+			achieved:  20 + 2*465.12,
+		},
+		{
+			name:      "plates below the 0.01 resolution are rejected",
+			target:    100,
+			bar:       20,
+			inventory: []float64{20, 0.001},
+			err:       ErrInvalidPlate,
+		},
+		{
+			name:      "plates above the maximum are rejected",
+			target:    100,
+			bar:       20,
+			inventory: []float64{MaxPlate + 1},
+			err:       ErrInvalidPlate,
+		},
+		{
+			name:   "an empty inventory is rejected",
+			target: 100,
+			bar:    20,
+			err:    ErrNoInventory,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			loadout, err := Calculate(tt.target, tt.bar, tt.inventory)
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("took %v", elapsed)
+			}
+
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Calculate error = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if toScaled(loadout.Achieved) != toScaled(tt.achieved) {
+				t.Errorf("achieved %v, want %v", loadout.Achieved, tt.achieved)
+			}
+			if tt.perSide != nil && !slices.Equal(loadout.PerSide, tt.perSide) {
+				t.Errorf("per side %v, want %v", loadout.PerSide, tt.perSide)
+			}
+		})
+	}
+}
+
+func TestValidPlate(t *testing.T) {
+	tests := []struct {
+		plate float64
+		want  bool
+	}{
+		{plate: 1.25, want: true},
+		{plate: 0.01, want: true},
+		{plate: MaxPlate, want: true},
+		{plate: 0.004, want: false},
+		{plate: 0.001, want: false},
+		{plate: 0, want: false},
+		{plate: -5, want: false},
+		{plate: MaxPlate + 0.5, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidPlate(tt.plate); got != tt.want {
+			t.Errorf("ValidPlate(%v) = %v, want %v", tt.plate, got, tt.want)
+		}
+	}
+}
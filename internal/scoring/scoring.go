@@ -0,0 +1,161 @@
+// Package scoring implements the bodyweight adjusted formulas used to compare
+// powerlifting totals across lifters of different size and sex.
+package scoring
+
+import (
+	"errors"
+	"math"
+)
+
+const (
+	Male   = "male"
+	Female = "female"
+)
+
+var ErrUnknownSex = errors.New("sex must be male or female")
+
+type coefficients struct {
+	poly          []float64
+	numerator     float64
+	minBodyweight float64
+	maxBodyweight float64
+}
+
+// the polynomial coefficients are ordered from the constant term upwards.
+var (
+	wilksMale = coefficients{
+		poly:          []float64{-216.0475144, 16.2606339, -0.002388645, -0.00113732, 7.01863e-06, -1.291e-08},
+		numerator:     500,
+		minBodyweight: 40,
+		maxBodyweight: 201.9,
+	}
+	wilksFemale = coefficients{
+		poly:          []float64{594.31747775582, -27.23842536447, 0.82112226871, -0.00930733913, 4.731582e-05, -9.054e-08},
+		numerator:     500,
+		minBodyweight: 26.51,
+		maxBodyweight: 154.53,
+	}
+	wilks2020Male = coefficients{
+		poly:          []float64{47.46178854, 8.472061379, 0.07369410346, -0.001395833811, 7.07665973070743e-06, -1.20804336482315e-08},
+		numerator:     600,
+		minBodyweight: 40,
+		maxBodyweight: 200.95,
+	}
+	wilks2020Female = coefficients{
+		poly:          []float64{-125.4255398, 13.71219419, -0.03307250631, -0.001050400051, 9.38773881462799e-06, -2.3334613884954e-08},
+		numerator:     600,
+		minBodyweight: 40,
+		maxBodyweight: 150.95,
+	}
+	dotsMale = coefficients{
+		poly:          []float64{-307.75076, 24.0900756, -0.1918759221, 0.0007391293, -0.000001093},
+		numerator:     500,
+		minBodyweight: 40,
+		maxBodyweight: 210,
+	}
+	dotsFemale = coefficients{
+		poly:          []float64{-57.96288, 13.6175032, -0.1126655495, 0.0005158568, -0.0000010706},
+		numerator:     500,
+		minBodyweight: 40,
+		maxBodyweight: 150,
+	}
+)
+
+// IPF GL parameters for classic (raw) three lift totals.
+var ipfGL = map[string]struct{ a, b, c float64 }{
+	Male:   {a: 1199.72839, b: 1025.18162, c: 0.00921},
+	Female: {a: 610.32796, b: 1045.59282, c: 0.03048},
+}
+
+func (c coefficients) score(total, bodyweight float64) float64 {
+	if total <= 0 || bodyweight <= 0 {
+		return 0
+	}
+
+	x := math.Min(math.Max(bodyweight, c.minBodyweight), c.maxBodyweight)
+	denominator := 0.0
+	for i := len(c.poly) - 1; i >= 0; i-- {
+		denominator = denominator*x + c.poly[i]
+	}
+	return round(total * c.numerator / denominator)
+}
+
+func pick(sex string, male, female coefficients) (coefficients, error) {
+	switch sex {
+	case Male:
+		return male, nil
+	case Female:
+		return female, nil
+	default:
+		return coefficients{}, ErrUnknownSex
+	}
+}
+
+// Wilks returns the original Wilks score of a total in kg.
+func Wilks(total, bodyweight float64, sex string) (float64, error) {
+	c, err := pick(sex, wilksMale, wilksFemale)
+	if err != nil {
+		return 0, err
+	}
+	return c.score(total, bodyweight), nil
+}
+
+// Wilks2020 returns the score using the coefficients revised in 2020.
+func Wilks2020(total, bodyweight float64, sex string) (float64, error) {
+	c, err := pick(sex, wilks2020Male, wilks2020Female)
+	if err != nil {
+		return 0, err
+	}
+	return c.score(total, bodyweight), nil
+}
+
+// Dots returns the DOTS score of a total in kg.
+func Dots(total, bodyweight float64, sex string) (float64, error) {
+	c, err := pick(sex, dotsMale, dotsFemale)
+	if err != nil {
+		return 0, err
+	}
+	return c.score(total, bodyweight), nil
+}
+
+// IPFGL returns the IPF GoodLift points of a classic three lift total in kg.
+func IPFGL(total, bodyweight float64, sex string) (float64, error) {
+	p, ok := ipfGL[sex]
+	if !ok {
+		return 0, ErrUnknownSex
+	}
+	if total <= 0 || bodyweight < 35 {
+		return 0, nil
+	}
+
+	denominator := p.a - p.b*math.Exp(-p.c*bodyweight)
+	if denominator <= 0 {
+		return 0, nil
+	}
+	return round(total * 100 / denominator), nil
+}
+
+type Scores struct {
+	Wilks     float64 `json:"wilks"`
+	Wilks2020 float64 `json:"wilks_2020"`
+	Dots      float64 `json:"dots"`
+	IPFGL     float64 `json:"ipf_gl"`
+}
+
+// All computes every supported score for the same total.
+func All(total, bodyweight float64, sex string) (Scores, error) {
+	if sex != Male && sex != Female {
+		return Scores{}, ErrUnknownSex
+	}
+
+	var s Scores
+	s.Wilks, _ = Wilks(total, bodyweight, sex)
+	s.Wilks2020, _ = Wilks2020(total, bodyweight, sex)
+	s.Dots, _ = Dots(total, bodyweight, sex)
+	s.IPFGL, _ = IPFGL(total, bodyweight, sex)
+	return s, nil
+}
+
+func round(f float64) float64 {
+	return math.Round(f*100) / 100
+}
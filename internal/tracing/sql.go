@@ -0,0 +1,320 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/XSAM/otelsql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OpenDB opens a pool like sql.Open that records a client span for every
+// query, prepared statement, transaction begin, commit and rollback run on it,
+// with the sanitized SQL and the number of rows a statement changed or a query
+// returned. dbSystem is the db.system attribute, such as postgresql or sqlite.
+func OpenDB(driverName, dsn, dbSystem string, provider trace.TracerProvider) (*sql.DB, error) {
+	db, err := sql.Open(driverName, "")
+	if err != nil {
+		return nil, err
+	}
+	d := db.Driver()
+	if err := db.Close(); err != nil {
+		return nil, err
+	}
+
+	var base driver.Connector = dsnConnector{dsn: dsn, driver: d}
+	if dc, ok := d.(driver.DriverContext); ok {
+		base, err = dc.OpenConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	counter := &rowCounter{
+		Connector: base,
+		tracer:    provider.Tracer(InstrumentationName),
+		dbSystem:  semconv.DBSystemKey.String(dbSystem),
+	}
+
+	return otelsql.OpenDB(counter,
+		otelsql.WithTracerProvider(provider),
+		otelsql.WithAttributes(semconv.DBSystemKey.String(dbSystem)),
+		otelsql.WithSpanNameFormatter(spanName),
+		otelsql.WithAttributesGetter(statement),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			// the statement is recorded sanitized by statement instead
+			DisableQuery:         true,
+			DisableErrSkip:       true,
+			OmitConnResetSession: true,
+			// the rows span is recorded by countedRows with the rows read
+			OmitRows: true,
+		})), nil
+}
+
+// spanName names a span after the first keyword of its statement, or after the
+// database/sql method for the ones without SQL such as sql.tx.commit.
+func spanName(ctx context.Context, method otelsql.Method, query string) string {
+	switch {
+	case query == "":
+		return string(method)
+	case method == otelsql.MethodConnPrepare:
+		return "PREPARE " + operation(SanitizeSQL(query))
+	}
+	return operation(SanitizeSQL(query))
+}
+
+func statement(ctx context.Context, method otelsql.Method, query string, args []driver.NamedValue) []attribute.KeyValue {
+	if query == "" {
+		return nil
+	}
+	return []attribute.KeyValue{semconv.DBStatementKey.String(SanitizeSQL(query))}
+}
+
+var (
+	sqlStringLiteral  = regexp.MustCompile(`'(?:[^']|'')*'`)
+	sqlLineComment    = regexp.MustCompile(`--[^\n]*`)
+	sqlNumericLiteral = regexp.MustCompile(`\$?\b\d+(?:\.\d+)?\b`)
+	sqlWhitespace     = regexp.MustCompile(`\s+`)
+)
+
+// SanitizeSQL replaces the string and numeric literals of query with ?, drops
+// its line comments and collapses its whitespace, so statements can be
+// recorded without the values that were spelled out in them. Placeholders
+// such as $1 are kept.
+func SanitizeSQL(query string) string {
+	query = sqlStringLiteral.ReplaceAllString(query, "?")
+	query = sqlLineComment.ReplaceAllString(query, "")
+	query = sqlNumericLiteral.ReplaceAllStringFunc(query, func(s string) string {
+		if strings.HasPrefix(s, "$") {
+			return s
+		}
+		return "?"
+	})
+	return strings.TrimSpace(sqlWhitespace.ReplaceAllString(query, " "))
+}
+
+// operation names a query span after the first keyword of the statement.
+func operation(statement string) string {
+	keyword, _, _ := strings.Cut(statement, " ")
+	keyword = strings.ToUpper(strings.TrimLeft(keyword, "("))
+	if keyword == "" {
+		return "SQL"
+	}
+	return keyword
+}
+
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// rowCounter sits between otelsql and the driver. The spans otelsql starts
+// end before the caller looks at the result or reads the rows, so it sets
+// db.rows_affected on the span of a statement as soon as it ran and records
+// the rows read in a sql.rows span of its own that ends when they are closed.
+type rowCounter struct {
+	driver.Connector
+	tracer   trace.Tracer
+	dbSystem attribute.KeyValue
+}
+
+func (c *rowCounter) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &countedConn{Conn: conn, counter: c}, nil
+}
+
+func (c *rowCounter) affected(ctx context.Context, res driver.Result) {
+	if n, err := res.RowsAffected(); err == nil {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("db.rows_affected", n))
+	}
+}
+
+func (c *rowCounter) rows(ctx context.Context, rows driver.Rows) driver.Rows {
+	_, span := c.tracer.Start(ctx, string(otelsql.MethodRows),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(c.dbSystem))
+	return &countedRows{Rows: rows, span: span}
+}
+
+// countedConn counts the rows of the queries run through the QueryerContext
+// and ExecerContext interfaces and of its prepared statements. Everything
+// else is passed through.
+type countedConn struct {
+	driver.Conn
+	counter *rowCounter
+}
+
+func (c *countedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return c.counter.rows(ctx, rows), nil
+}
+
+func (c *countedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	res, err := execer.ExecContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	c.counter.affected(ctx, res)
+	return res, nil
+}
+
+func (c *countedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the context methods cannot return driver.ErrSkip, statements without
+	// them are left uncounted
+	_, execer := stmt.(driver.StmtExecContext)
+	_, queryer := stmt.(driver.StmtQueryContext)
+	if !execer || !queryer {
+		return stmt, nil
+	}
+	return &countedStmt{Stmt: stmt, counter: c.counter}, nil
+}
+
+func (c *countedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin() //nolint:staticcheck
+}
+
+func (c *countedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *countedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *countedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+func (c *countedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type countedStmt struct {
+	driver.Stmt
+	counter *rowCounter
+}
+
+func (s *countedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	res, err := s.Stmt.(driver.StmtExecContext).ExecContext(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	s.counter.affected(ctx, res)
+	return res, nil
+}
+
+func (s *countedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := s.Stmt.(driver.StmtQueryContext).QueryContext(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return s.counter.rows(ctx, rows), nil
+}
+
+func (s *countedStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// countedRows ends its span when the rows are closed, counting the rows read.
+type countedRows struct {
+	driver.Rows
+	span  trace.Span
+	count int64
+}
+
+func (r *countedRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	switch {
+	case err == nil:
+		r.count++
+	case !errors.Is(err, io.EOF):
+		r.span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+func (r *countedRows) Close() error {
+	err := r.Rows.Close()
+	r.span.SetAttributes(attribute.Int64("db.rows_returned", r.count))
+	r.span.End()
+	return err
+}
+
+func (r *countedRows) HasNextResultSet() bool {
+	if v, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return v.HasNextResultSet()
+	}
+	return false
+}
+
+func (r *countedRows) NextResultSet() error {
+	if v, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return v.NextResultSet()
+	}
+	return io.EOF
+}
+
+func (r *countedRows) ColumnTypeDatabaseTypeName(index int) string {
+	if v, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return v.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
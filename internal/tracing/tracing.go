@@ -0,0 +1,78 @@
+// Package tracing sets up the OpenTelemetry SDK for the API: a tracer provider
+// batching spans to an exporter, the exporters the -trace-exporter flag can
+// choose from and the instrumentation of the database connection pool.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// InstrumentationName names the tracer of the API's own spans.
+const InstrumentationName = "workout-microservice"
+
+// New returns a tracer provider batching spans to exporter. The resource
+// attributes, such as service.name, describe the process and onError is told
+// about failed exports. It also installs the W3C trace context propagator, so
+// traces are continued from and into the traceparent header.
+func New(exporter sdktrace.SpanExporter, onError func(error), attributes ...attribute.KeyValue) *sdktrace.TracerProvider {
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(onError))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attributes...)))
+}
+
+// NewWriterExporter returns an exporter writing every span to w as a line of
+// JSON. w is left open on shutdown, it belongs to the caller.
+func NewWriterExporter(w io.Writer) (sdktrace.SpanExporter, error) {
+	return stdouttrace.New(stdouttrace.WithWriter(w))
+}
+
+// NewFileExporter returns an exporter appending every span to the file at path
+// as a line of JSON, creating it when it does not exist. The file is closed on
+// shutdown.
+func NewFileExporter(path string) (sdktrace.SpanExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return fileExporter{SpanExporter: exporter, file: f}, nil
+}
+
+type fileExporter struct {
+	sdktrace.SpanExporter
+	file *os.File
+}
+
+func (e fileExporter) Shutdown(ctx context.Context) error {
+	err := e.SpanExporter.Shutdown(ctx)
+	return errors.Join(err, e.file.Close())
+}
+
+// NewOTLPExporter returns an OTLP/HTTP exporter for the collector at endpoint,
+// such as http://localhost:4318. A full URL ending in /v1/traces is used as it is.
+func NewOTLPExporter(ctx context.Context, endpoint string) (sdktrace.SpanExporter, error) {
+	url := strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	return otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(url))
+}
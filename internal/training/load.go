@@ -0,0 +1,162 @@
+// Package training computes training load and fatigue indicators, such as the
+// acute:chronic workload ratio, from a user's daily training totals.
+package training
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+const (
+	MetricSessionRpe = "srpe"
+	MetricVolume     = "volume"
+)
+
+const (
+	FlagSpike = "spike"
+	FlagLow   = "low"
+)
+
+const (
+	AcuteDays   = 7
+	ChronicDays = 28
+)
+
+var ErrUnknownMetric = errors.New("metric must be srpe or volume")
+
+// DailyLoad is the total training done by a user on a single calendar day.
+// SessionRpeLoad is the sum of rpe * duration over the rated sessions and
+// VolumeLoad the sum of weight * reps over every set.
+type DailyLoad struct {
+	Date           time.Time `json:"date"`
+	Sessions       int       `json:"sessions"`
+	SessionRpeLoad float64   `json:"srpe_load"`
+	VolumeLoad     float64   `json:"volume_load"`
+}
+
+// Thresholds are the ACWR bounds outside of which a day is flagged.
+type Thresholds struct {
+	High float64 `json:"high"`
+	Low  float64 `json:"low"`
+}
+
+type Day struct {
+	DailyLoad
+	Load    float64  `json:"load"`
+	Acute   float64  `json:"acute_7d"`
+	Chronic float64  `json:"chronic_28d"`
+	Acwr    *float64 `json:"acwr"`
+	Flag    string   `json:"flag,omitempty"`
+}
+
+type Report struct {
+	Metric     string     `json:"metric"`
+	Thresholds Thresholds `json:"thresholds"`
+	Days       []Day      `json:"days"`
+	Flagged    []Day      `json:"flagged"`
+}
+
+func (d DailyLoad) load(metric string) float64 {
+	if metric == MetricVolume {
+		return d.VolumeLoad
+	}
+	return d.SessionRpeLoad
+}
+
+// Truncate returns the start of the UTC day t falls in.
+func Truncate(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// HistoryStart is the first day that has to be loaded to compute the chronic
+// load of from.
+func HistoryStart(from time.Time) time.Time {
+	return Truncate(from).AddDate(0, 0, -(ChronicDays - 1))
+}
+
+// Compute builds the daily report between from and to (both inclusive). daily
+// must cover HistoryStart(from) onwards, days without training can be omitted.
+//
+// The acute load is the 7 day rolling sum and the chronic load the 28 day
+// rolling sum expressed per week, so an ACWR of 1 means the last week matched
+// the average of the last four.
+func Compute(daily []DailyLoad, from, to time.Time, metric string, t Thresholds) (Report, error) {
+	if metric != MetricSessionRpe && metric != MetricVolume {
+		return Report{}, ErrUnknownMetric
+	}
+
+	byDay := make(map[time.Time]DailyLoad, len(daily))
+	for _, d := range daily {
+		day := Truncate(d.Date)
+		existing := byDay[day]
+		existing.Date = day
+		existing.Sessions += d.Sessions
+		existing.SessionRpeLoad += d.SessionRpeLoad
+		existing.VolumeLoad += d.VolumeLoad
+		byDay[day] = existing
+	}
+
+	report := Report{
+		Metric:     metric,
+		Thresholds: t,
+		Days:       []Day{},
+		Flagged:    []Day{},
+	}
+
+	first, last := Truncate(from), Truncate(to)
+	var window []float64
+	for day := HistoryStart(from); !day.After(last); day = day.AddDate(0, 0, 1) {
+		d := byDay[day]
+		d.Date = day
+		window = append(window, d.load(metric))
+		if len(window) > ChronicDays {
+			window = window[1:]
+		}
+		if day.Before(first) {
+			continue
+		}
+
+		out := Day{DailyLoad: d, Load: d.load(metric)}
+		out.Acute = sum(window[max(0, len(window)-AcuteDays):])
+		out.Chronic = sum(window) / (ChronicDays / AcuteDays)
+		if out.Chronic > 0 {
+			acwr := math.Round(out.Acute/out.Chronic*100) / 100
+			out.Acwr = &acwr
+			switch {
+			case t.High > 0 && acwr > t.High:
+				out.Flag = FlagSpike
+			case t.Low > 0 && acwr < t.Low:
+				out.Flag = FlagLow
+			}
+		}
+
+		report.Days = append(report.Days, out)
+		if out.Flag != "" {
+			report.Flagged = append(report.Flagged, out)
+		}
+	}
+	return report, nil
+}
+
+func sum(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+// E1RM estimates the one rep max of a set with the Epley formula. A single rep
+// is taken at face value and sets without reps estimate nothing.
+func E1RM(weight, reps int) float64 {
+	switch {
+	case reps <= 0:
+		return 0
+	case reps == 1:
+		return float64(weight)
+	default:
+		return float64(weight) * (1 + float64(reps)/30)
+	}
+}
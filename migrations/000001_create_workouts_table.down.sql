@@ -1 +1,3 @@
-DROP TABLE IF EXISTS workouts_table;
\ No newline at end of file
+DROP TABLE IF EXISTS workouts_table;
+
+DROP TABLE IF EXISTS exercises;
@@ -1,3 +1,12 @@
+-- workouts_table references exercises, which only gets its own migration in
+-- 000002. It is created here first so that an empty database can be migrated;
+-- databases that already have it keep theirs.
+CREATE TABLE IF NOT EXISTS exercises (
+    exercise_id bigserial PRIMARY KEY,
+    exercise_name text NOT NULL,
+    exercise_description text
+);
+
 CREATE TABLE IF NOT EXISTS workouts_table (
     workout_id bigserial,
     user_id int,
@@ -18,4 +27,4 @@ ALTER TABLE workouts_table ADD CONSTRAINT REPS_CONSTRAINTS CHECK (array_length(r
 
 ALTER TABLE workouts_table ADD CONSTRAINT WEIGHTS_CONSTRAINTS CHECK (array_length(weights, 1) > 0);
 
-ALTER TABLE workouts_table ADD CONSTRAINT LENGTH_CONSTRAINTS CHECK (array_length(reps, 1) = sets AND array_length(weights, 1) = sets);
\ No newline at end of file
+ALTER TABLE workouts_table ADD CONSTRAINT LENGTH_CONSTRAINTS CHECK (array_length(reps, 1) = sets AND array_length(weights, 1) = sets);
@@ -1 +1,2 @@
-DROP TABLE IF EXISTS exercises;
\ No newline at end of file
+-- exercises is dropped by 000001 down, once workouts_table no longer
+-- references it.
@@ -2,4 +2,4 @@ CREATE TABLE IF NOT EXISTS exercises (
     exercise_id bigserial PRIMARY KEY,
     exercise_name text NOT NULL,
     exercise_description text
-);
\ No newline at end of file
+);
@@ -1 +1 @@
-DROP TABLE IF EXISTS exercises_prs;
\ No newline at end of file
+DROP TABLE IF EXISTS exercise_prs;
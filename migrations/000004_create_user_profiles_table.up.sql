@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS user_profiles (
+    user_id int PRIMARY KEY,
+    bodyweight double precision,
+    sex text,
+    leaderboard_opt_in boolean NOT NULL DEFAULT false,
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE user_profiles ADD CONSTRAINT BODYWEIGHT_CONSTRAINTS CHECK (bodyweight IS NULL OR bodyweight > 0);
+
+ALTER TABLE user_profiles ADD CONSTRAINT SEX_CONSTRAINTS CHECK (sex IS NULL OR sex IN ('male', 'female'));
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS workouts_table_exercise_id_idx;
+
+DROP INDEX IF EXISTS exercise_prs_leaderboard_idx;
+
+ALTER TABLE exercise_prs DROP COLUMN IF EXISTS achieved_at;
@@ -0,0 +1,5 @@
+ALTER TABLE exercise_prs ADD COLUMN IF NOT EXISTS achieved_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS exercise_prs_leaderboard_idx ON exercise_prs (exercise_id, pr DESC);
+
+CREATE INDEX IF NOT EXISTS workouts_table_exercise_id_idx ON workouts_table (exercise_id, created_at);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS achievements (
+    user_id int NOT NULL,
+    code text NOT NULL,
+    workout_id bigint,
+    earned_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, code)
+);
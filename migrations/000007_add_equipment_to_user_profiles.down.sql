@@ -0,0 +1,9 @@
+ALTER TABLE user_profiles DROP CONSTRAINT IF EXISTS BAR_WEIGHT_CONSTRAINTS;
+
+ALTER TABLE user_profiles DROP CONSTRAINT IF EXISTS UNIT_CONSTRAINTS;
+
+ALTER TABLE user_profiles DROP COLUMN IF EXISTS plates;
+
+ALTER TABLE user_profiles DROP COLUMN IF EXISTS bar_weight;
+
+ALTER TABLE user_profiles DROP COLUMN IF EXISTS unit;
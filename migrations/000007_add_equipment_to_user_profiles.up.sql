@@ -0,0 +1,9 @@
+ALTER TABLE user_profiles ADD COLUMN IF NOT EXISTS unit text NOT NULL DEFAULT 'kg';
+
+ALTER TABLE user_profiles ADD COLUMN IF NOT EXISTS bar_weight double precision;
+
+ALTER TABLE user_profiles ADD COLUMN IF NOT EXISTS plates double precision[];
+
+ALTER TABLE user_profiles ADD CONSTRAINT UNIT_CONSTRAINTS CHECK (unit IN ('kg', 'lb'));
+
+ALTER TABLE user_profiles ADD CONSTRAINT BAR_WEIGHT_CONSTRAINTS CHECK (bar_weight IS NULL OR bar_weight >= 0);
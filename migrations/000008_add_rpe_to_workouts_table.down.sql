@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS workouts_table_user_id_created_at_idx;
+
+ALTER TABLE workouts_table DROP CONSTRAINT IF EXISTS RPE_CONSTRAINTS;
+
+ALTER TABLE workouts_table DROP COLUMN IF EXISTS rpe;
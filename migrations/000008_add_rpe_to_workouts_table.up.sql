@@ -0,0 +1,5 @@
+ALTER TABLE workouts_table ADD COLUMN IF NOT EXISTS rpe real;
+
+ALTER TABLE workouts_table ADD CONSTRAINT RPE_CONSTRAINTS CHECK (rpe IS NULL OR (rpe >= 1 AND rpe <= 10));
+
+CREATE INDEX IF NOT EXISTS workouts_table_user_id_created_at_idx ON workouts_table (user_id, created_at);
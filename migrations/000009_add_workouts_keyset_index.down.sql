@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS workouts_table_user_id_created_at_workout_id_idx;
+
+CREATE INDEX IF NOT EXISTS workouts_table_user_id_created_at_idx ON workouts_table (user_id, created_at);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS workouts_table_user_id_created_at_idx;
+
+CREATE INDEX IF NOT EXISTS workouts_table_user_id_created_at_workout_id_idx
+    ON workouts_table (user_id, created_at, workout_id);
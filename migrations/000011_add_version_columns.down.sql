@@ -0,0 +1,3 @@
+ALTER TABLE workouts_table DROP COLUMN IF EXISTS version;
+
+ALTER TABLE exercises DROP COLUMN IF EXISTS exercise_version;
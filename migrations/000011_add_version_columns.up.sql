@@ -0,0 +1,3 @@
+ALTER TABLE exercises ADD COLUMN IF NOT EXISTS exercise_version int NOT NULL DEFAULT 1;
+
+ALTER TABLE workouts_table ADD COLUMN IF NOT EXISTS version int NOT NULL DEFAULT 1;
@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS pr_deletion_trigger ON workouts_table;
+
+DROP TRIGGER IF EXISTS pr_updating_trigger ON workouts_table;
+
+DROP FUNCTION IF EXISTS pr_deleting_function();
+
+DROP FUNCTION IF EXISTS pr_updating_function();
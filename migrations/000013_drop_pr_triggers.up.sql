@@ -0,0 +1,9 @@
+-- PRs are maintained by the workouts model from here on, see internal/data/prservice.go.
+
+DROP TRIGGER IF EXISTS pr_deletion_trigger ON workouts_table;
+
+DROP TRIGGER IF EXISTS pr_updating_trigger ON workouts_table;
+
+DROP FUNCTION IF EXISTS pr_deleting_function();
+
+DROP FUNCTION IF EXISTS pr_updating_function();
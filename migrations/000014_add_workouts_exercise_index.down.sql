@@ -0,0 +1 @@
+DROP INDEX IF EXISTS workouts_table_exercise_id_created_at_idx;
@@ -0,0 +1,3 @@
+-- The e1rm leaderboard scores every set of an exercise, optionally within a
+-- date range. Without this index it has to read the workouts of every exercise.
+CREATE INDEX IF NOT EXISTS workouts_table_exercise_id_created_at_idx ON workouts_table (exercise_id, created_at);
@@ -0,0 +1,7 @@
+DELETE FROM idempotency_keys;
+
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS headers;
+
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS content_type text;
+
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS location text;
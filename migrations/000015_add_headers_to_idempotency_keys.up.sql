@@ -0,0 +1,10 @@
+-- Replayed responses carry every header the handler set instead of only
+-- Content-Type and Location. Keys are scoped per client and endpoint from here
+-- on, so the keys stored so far can no longer be matched.
+DELETE FROM idempotency_keys;
+
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS content_type;
+
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS location;
+
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS headers jsonb;
@@ -0,0 +1,23 @@
+-- The e1rm leaderboard ranks the best estimated one rep max kept next to the
+-- PR instead of scoring every set of the exercise, so the workouts no longer
+-- need to be found by exercise.
+ALTER TABLE exercise_prs ADD COLUMN IF NOT EXISTS e1rm double precision NOT NULL DEFAULT 0;
+
+ALTER TABLE exercise_prs ADD COLUMN IF NOT EXISTS e1rm_achieved_at timestamp(0) with time zone;
+
+UPDATE exercise_prs SET (e1rm, e1rm_achieved_at) = (best.e1rm, best.achieved_at)
+FROM (
+    SELECT DISTINCT ON (user_id, exercise_id) user_id, exercise_id,
+    (CASE WHEN s.reps <= 1 THEN s.weight ELSE s.weight * (1 + s.reps / 30.0) END)::double precision AS e1rm,
+    created_at AS achieved_at
+    FROM workouts_table, unnest(weights, reps) AS s(weight, reps)
+    WHERE s.reps > 0
+    ORDER BY user_id, exercise_id, e1rm DESC, created_at
+) AS best
+WHERE (exercise_prs.user_id, exercise_prs.exercise_id) = (best.user_id, best.exercise_id);
+
+CREATE INDEX IF NOT EXISTS exercise_prs_e1rm_idx ON exercise_prs (exercise_id, e1rm DESC);
+
+DROP INDEX IF EXISTS workouts_table_exercise_id_idx;
+
+DROP INDEX IF EXISTS workouts_table_exercise_id_created_at_idx;
@@ -0,0 +1,19 @@
+// Package migrations embeds the SQL schema migrations so that they ship with
+// the api binary. Files are named NNNNNN_description.up.sql and
+// NNNNNN_description.down.sql and are applied in version order. The SQLite
+// schema has its own set in the sqlite directory.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed *.sql
+var FS embed.FS
+
+//go:embed sqlite/*.sql
+var sqliteFS embed.FS
+
+// SQLiteFS holds the migrations of the SQLite schema.
+var SQLiteFS, _ = fs.Sub(sqliteFS, "sqlite")
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS idempotency_keys;
+
+DROP TABLE IF EXISTS achievements;
+
+DROP TABLE IF EXISTS user_profiles;
+
+DROP TABLE IF EXISTS exercise_prs;
+
+DROP TABLE IF EXISTS workouts_table;
+
+DROP TABLE IF EXISTS exercises;
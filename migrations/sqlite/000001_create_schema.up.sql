@@ -0,0 +1,68 @@
+-- The SQLite schema matches the Postgres one at version 000013. Arrays are
+-- stored as JSON and timestamps as unix seconds.
+CREATE TABLE IF NOT EXISTS exercises (
+    exercise_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    exercise_name TEXT NOT NULL,
+    exercise_description TEXT,
+    exercise_version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS workouts_table (
+    workout_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    exercise_id INTEGER NOT NULL REFERENCES exercises (exercise_id),
+    duration INTEGER,
+    sets INTEGER NOT NULL CHECK (sets > 0),
+    reps TEXT NOT NULL CHECK (json_array_length(reps) = sets),
+    weights TEXT NOT NULL CHECK (json_array_length(weights) = sets),
+    rpe REAL CHECK (rpe IS NULL OR (rpe >= 1 AND rpe <= 10)),
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS workouts_table_user_id_created_at_workout_id_idx
+    ON workouts_table (user_id, created_at, workout_id);
+
+CREATE INDEX IF NOT EXISTS workouts_table_exercise_id_idx ON workouts_table (exercise_id, created_at);
+
+CREATE TABLE IF NOT EXISTS exercise_prs (
+    user_id INTEGER NOT NULL,
+    exercise_id INTEGER NOT NULL REFERENCES exercises (exercise_id),
+    pr INTEGER,
+    achieved_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    PRIMARY KEY (user_id, exercise_id)
+);
+
+CREATE INDEX IF NOT EXISTS exercise_prs_leaderboard_idx ON exercise_prs (exercise_id, pr DESC);
+
+CREATE TABLE IF NOT EXISTS user_profiles (
+    user_id INTEGER PRIMARY KEY,
+    bodyweight REAL CHECK (bodyweight IS NULL OR bodyweight > 0),
+    sex TEXT CHECK (sex IS NULL OR sex IN ('male', 'female')),
+    leaderboard_opt_in INTEGER NOT NULL DEFAULT 0,
+    unit TEXT NOT NULL DEFAULT 'kg' CHECK (unit IN ('kg', 'lb')),
+    bar_weight REAL CHECK (bar_weight IS NULL OR bar_weight >= 0),
+    plates TEXT NOT NULL DEFAULT '[]',
+    updated_at INTEGER NOT NULL DEFAULT (unixepoch())
+);
+
+CREATE TABLE IF NOT EXISTS achievements (
+    user_id INTEGER NOT NULL,
+    code TEXT NOT NULL,
+    workout_id INTEGER,
+    earned_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    PRIMARY KEY (user_id, code)
+);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    idempotency_key TEXT PRIMARY KEY,
+    fingerprint BLOB NOT NULL,
+    status INTEGER,
+    content_type TEXT,
+    location TEXT,
+    body BLOB,
+    created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    expires_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,7 @@
+DELETE FROM idempotency_keys;
+
+ALTER TABLE idempotency_keys DROP COLUMN headers;
+
+ALTER TABLE idempotency_keys ADD COLUMN content_type TEXT;
+
+ALTER TABLE idempotency_keys ADD COLUMN location TEXT;
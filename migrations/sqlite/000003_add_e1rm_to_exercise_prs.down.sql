@@ -0,0 +1,7 @@
+CREATE INDEX IF NOT EXISTS workouts_table_exercise_id_idx ON workouts_table (exercise_id, created_at);
+
+DROP INDEX IF EXISTS exercise_prs_e1rm_idx;
+
+ALTER TABLE exercise_prs DROP COLUMN e1rm_achieved_at;
+
+ALTER TABLE exercise_prs DROP COLUMN e1rm;
@@ -0,0 +1,26 @@
+-- The e1rm leaderboard ranks the best estimated one rep max kept next to the
+-- PR instead of scoring every set of the exercise, so the workouts no longer
+-- need to be found by exercise.
+ALTER TABLE exercise_prs ADD COLUMN e1rm REAL NOT NULL DEFAULT 0;
+
+ALTER TABLE exercise_prs ADD COLUMN e1rm_achieved_at INTEGER;
+
+UPDATE exercise_prs SET e1rm = best.e1rm, e1rm_achieved_at = best.achieved_at
+FROM (
+    SELECT user_id, exercise_id, e1rm, achieved_at FROM (
+        SELECT user_id, exercise_id, e1rm, achieved_at,
+        ROW_NUMBER() OVER (PARTITION BY user_id, exercise_id ORDER BY e1rm DESC, achieved_at) AS n
+        FROM (
+            SELECT user_id, exercise_id,
+            CASE WHEN r.value <= 1 THEN CAST(w.value AS REAL) ELSE w.value * (1 + r.value / 30.0) END AS e1rm,
+            created_at AS achieved_at
+            FROM workouts_table, json_each(weights) AS w JOIN json_each(reps) AS r ON r.key = w.key
+            WHERE r.value > 0
+        )
+    ) WHERE n = 1
+) AS best
+WHERE exercise_prs.user_id = best.user_id AND exercise_prs.exercise_id = best.exercise_id;
+
+CREATE INDEX IF NOT EXISTS exercise_prs_e1rm_idx ON exercise_prs (exercise_id, e1rm DESC);
+
+DROP INDEX IF EXISTS workouts_table_exercise_id_idx;